@@ -0,0 +1,207 @@
+package main
+
+import (
+	"crypto/tls"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Result categories assigned by the block-page classifier
+const (
+	CategoryOK            = "ok"
+	CategoryFiltered      = "filtered"
+	CategoryGeoBlocked    = "geo_blocked"
+	CategoryCaptivePortal = "captive_portal"
+	CategoryChallenge     = "challenge"
+	CategoryTCPReset      = "tcp_reset"
+	CategoryConnRefused   = "connection_refused" // nothing listens, or a firewall rejects the port
+	CategoryTLSReset      = "tls_reset"
+	CategoryTLSError      = "tls_error"
+	CategoryTimeout       = "timeout"
+	CategoryDNSFailure    = "dns_failure"
+	CategoryHTTPError     = "http_error"
+	CategoryNetworkError  = "network_error"
+)
+
+//go:embed signatures/blockpages.json
+var defaultSignatures []byte
+
+// Signature describes one known block page. Every condition that is set must match.
+type Signature struct {
+	ID           string   `json:"id"`
+	Category     string   `json:"category"`
+	Description  string   `json:"description"`
+	Status       []int    `json:"status,omitempty"`
+	URLRegex     string   `json:"url_regex,omitempty"`
+	BodyContains []string `json:"body_contains,omitempty"` // any of these, case-insensitive
+	BodyRegex    string   `json:"body_regex,omitempty"`
+
+	urlRe  *regexp.Regexp
+	bodyRe *regexp.Regexp
+}
+
+// SignatureSet is the versioned content of the signatures data file
+type SignatureSet struct {
+	Version    int         `json:"version"`
+	Updated    string      `json:"updated"`
+	Signatures []Signature `json:"signatures"`
+}
+
+// Classification is the labelled outcome of an HTTP check
+type Classification struct {
+	Category  string `json:"category"`
+	Signature string `json:"signature,omitempty"`
+	Detail    string `json:"detail,omitempty"`
+}
+
+var (
+	signaturesOnce sync.Once
+	signatures     *SignatureSet
+)
+
+// loadSignatures reads BLOCKPAGE_SIGNATURES if set, falling back to the embedded file
+func loadSignatures() *SignatureSet {
+	signaturesOnce.Do(func() {
+		set, err := parseSignatures(defaultSignatures)
+		if err != nil {
+			set = &SignatureSet{}
+		}
+
+		if path := os.Getenv("BLOCKPAGE_SIGNATURES"); path != "" {
+			custom, err := os.ReadFile(path)
+			if err == nil {
+				var parsed *SignatureSet
+				if parsed, err = parseSignatures(custom); err == nil {
+					set = parsed
+				}
+			}
+			if err != nil {
+				fmt.Println(colorMap["yellow"], "[Warning] Can't load signatures file, using built-in set:", err)
+			}
+		}
+		signatures = set
+	})
+	return signatures
+}
+
+func parseSignatures(data []byte) (*SignatureSet, error) {
+	var set SignatureSet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	for i := range set.Signatures {
+		sig := &set.Signatures[i]
+		var err error
+		if sig.URLRegex != "" {
+			if sig.urlRe, err = regexp.Compile(sig.URLRegex); err != nil {
+				return nil, fmt.Errorf("signature %s: %w", sig.ID, err)
+			}
+		}
+		if sig.BodyRegex != "" {
+			if sig.bodyRe, err = regexp.Compile(sig.BodyRegex); err != nil {
+				return nil, fmt.Errorf("signature %s: %w", sig.ID, err)
+			}
+		}
+	}
+	return &set, nil
+}
+
+func (s *Signature) matches(status int, finalURL, body string) bool {
+	if len(s.Status) > 0 && !containsInt(s.Status, status) {
+		return false
+	}
+	if s.urlRe != nil && !s.urlRe.MatchString(finalURL) {
+		return false
+	}
+	if s.bodyRe != nil && !s.bodyRe.MatchString(body) {
+		return false
+	}
+	if len(s.BodyContains) > 0 {
+		lower := strings.ToLower(body)
+		found := false
+		for _, marker := range s.BodyContains {
+			if strings.Contains(lower, strings.ToLower(marker)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return s.urlRe != nil || s.bodyRe != nil || len(s.BodyContains) > 0
+}
+
+func containsInt(slice []int, n int) bool {
+	for _, v := range slice {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
+
+// classifyResponse labels a completed HTTP exchange using the signature set
+func classifyResponse(response *http.Response, body string) Classification {
+	finalURL := ""
+	if response.Request != nil && response.Request.URL != nil {
+		finalURL = response.Request.URL.String()
+	}
+
+	set := loadSignatures()
+	for i := range set.Signatures {
+		sig := &set.Signatures[i]
+		if sig.matches(response.StatusCode, finalURL, body) {
+			return Classification{Category: sig.Category, Signature: sig.ID, Detail: sig.Description}
+		}
+	}
+
+	if response.StatusCode >= 400 {
+		return Classification{Category: CategoryHTTPError, Detail: response.Status}
+	}
+	return Classification{Category: CategoryOK}
+}
+
+// classifyError labels a failed HTTP exchange from the transport error
+func classifyError(err error) Classification {
+	// a redirect into the filtering range usually fails to connect, so check the URL we were sent to
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		set := loadSignatures()
+		for i := range set.Signatures {
+			sig := &set.Signatures[i]
+			if sig.urlRe != nil && len(sig.Status) == 0 && sig.bodyRe == nil && len(sig.BodyContains) == 0 && sig.urlRe.MatchString(urlErr.URL) {
+				return Classification{Category: sig.Category, Signature: sig.ID, Detail: err.Error()}
+			}
+		}
+	}
+
+	msg := strings.ToLower(err.Error())
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var certErr *tls.CertificateVerificationError
+	switch {
+	case errors.As(err, &dnsErr) || strings.Contains(msg, "no such host"):
+		return Classification{Category: CategoryDNSFailure, Detail: err.Error()}
+	case errors.As(err, &netErr) && netErr.Timeout(), strings.Contains(msg, "timeout"):
+		return Classification{Category: CategoryTimeout, Detail: err.Error()}
+	case errors.As(err, &certErr) || strings.Contains(msg, "x509:"):
+		return Classification{Category: CategoryTLSError, Detail: err.Error()}
+	case strings.Contains(msg, "tls:") || strings.Contains(msg, "handshake"):
+		return Classification{Category: CategoryTLSReset, Detail: err.Error()}
+	case strings.Contains(msg, "connection refused"):
+		return Classification{Category: CategoryConnRefused, Detail: err.Error()}
+	case strings.Contains(msg, "connection reset") || strings.Contains(msg, "broken pipe"):
+		return Classification{Category: CategoryTCPReset, Detail: err.Error()}
+	}
+	return Classification{Category: CategoryNetworkError, Detail: err.Error()}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestClassifyRedirectTargets(t *testing.T) {
+	for target, want := range map[string]string{
+		"http://10.10.34.35/":                                CategoryFiltered,
+		"http://192.168.88.1/login?dst=http%3A%2F%2Fexample": CategoryCaptivePortal,
+		"http://172.20.1.1:8002/index.php?zone=guests":       CategoryCaptivePortal,
+		"http://100.64.0.1/":                                 CategoryCaptivePortal,
+		"https://hotspot.lan/":                               CategoryCaptivePortal,
+		"https://wifi.example.net/guest/s/default/":          CategoryCaptivePortal,
+		"http://gw.example.org/wifidog/login/?gw_id=1":       CategoryCaptivePortal,
+		// legitimate sign-in redirects of public services
+		"https://login.microsoftonline.com/common/oauth2/v2.0/authorize": CategoryOK,
+		"https://portal.azure.com/":                                      CategoryOK,
+		"https://github.com/login":                                       CategoryOK,
+		"https://10.example.com/":                                        CategoryOK,
+	} {
+		u, _ := url.Parse(target)
+		response := &http.Response{StatusCode: http.StatusOK, Request: &http.Request{URL: u}}
+		if got := classifyResponse(response, "<html></html>"); got.Category != want {
+			t.Errorf("%s: %s (%s), want %s", target, got.Category, got.Signature, want)
+		}
+	}
+}
+
+func TestClassifyConnectionErrors(t *testing.T) {
+	for msg, want := range map[string]string{
+		"dial tcp 192.0.2.1:443: connect: connection refused": CategoryConnRefused,
+		"read tcp 192.0.2.1:443: connection reset by peer":    CategoryTCPReset,
+		"write tcp 192.0.2.1:443: broken pipe":                CategoryTCPReset,
+	} {
+		err := &url.Error{Op: "Get", URL: "https://service.test/", Err: errors.New(msg)}
+		if got := classifyError(err); got.Category != want {
+			t.Errorf("%s: %s, want %s", msg, got.Category, want)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const captivePortalTimeout = 5 * time.Second
+
+// captiveProbe is a plain HTTP endpoint with a known answer that portals cannot fake
+type captiveProbe struct {
+	URL    string
+	Status int
+	Body   string // substring the body must contain, if set
+}
+
+// captivePortalProbes returns CAPTIVE_PORTAL_URLS, each expected to answer 204 like
+// generate_204, or the Android and Apple connectivity checks
+func captivePortalProbes() []captiveProbe {
+	if urls := envList("CAPTIVE_PORTAL_URLS"); len(urls) > 0 {
+		probes := make([]captiveProbe, 0, len(urls))
+		for _, u := range urls {
+			probes = append(probes, captiveProbe{URL: u, Status: http.StatusNoContent})
+		}
+		return probes
+	}
+	return []captiveProbe{
+		{URL: "http://connectivitycheck.gstatic.com/generate_204", Status: http.StatusNoContent},
+		{URL: "http://captive.apple.com/hotspot-detect.html", Status: http.StatusOK, Body: "Success"},
+	}
+}
+
+// CaptivePortalProbe is the answer to one connectivity check
+type CaptivePortalProbe struct {
+	URL            string          `json:"url"`
+	Status         int             `json:"status,omitempty"`
+	Location       string          `json:"location,omitempty"`
+	Passed         bool            `json:"passed"`
+	Intercepted    bool            `json:"intercepted"`
+	Reason         string          `json:"reason,omitempty"`
+	Classification *Classification `json:"classification,omitempty"`
+	Error          string          `json:"error,omitempty"`
+}
+
+// CaptivePortalResult says whether the network holds traffic behind a login page
+type CaptivePortalResult struct {
+	Detected  bool                 `json:"detected"`
+	PortalURL string               `json:"portal_url,omitempty"`
+	Probes    []CaptivePortalProbe `json:"probes"`
+}
+
+func runCaptiveProbe(probe captiveProbe) CaptivePortalProbe {
+	result := CaptivePortalProbe{URL: probe.URL}
+	client := newHTTPClient(captivePortalTimeout, "")
+	// a portal announces itself with the redirect, so it must not be followed
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+
+	response, err := client.Get(probe.URL)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(response.Body, 64<<10))
+	result.Status = response.StatusCode
+
+	redirected := response.StatusCode >= 300 && response.StatusCode < 400
+	if !redirected && response.StatusCode == probe.Status && strings.Contains(string(body), probe.Body) {
+		result.Passed = true
+		return result
+	}
+
+	class := classifyResponse(response, string(body))
+	if class.Category != CategoryOK {
+		result.Classification = &class
+	}
+	switch {
+	case redirected:
+		result.Intercepted = true
+		result.Location = response.Header.Get("Location")
+		result.Reason = "redirected to " + result.Location
+	case class.Category == CategoryFiltered:
+		// the national filter answering is not a portal
+		result.Reason = "filtered"
+	case class.Category == CategoryCaptivePortal:
+		result.Intercepted = true
+		result.Reason = class.Detail
+	case response.StatusCode != probe.Status:
+		result.Intercepted = true
+		result.Reason = fmt.Sprintf("expected status %d, got %d", probe.Status, response.StatusCode)
+	default:
+		result.Intercepted = true
+		result.Reason = "content substituted"
+	}
+	return result
+}
+
+// detectCaptivePortal runs every connectivity check. A portal is only reported when some
+// check was intercepted and none came through untouched, as portals hold all traffic.
+func detectCaptivePortal() *CaptivePortalResult {
+	result := &CaptivePortalResult{}
+	passed := false
+	for _, probe := range captivePortalProbes() {
+		p := runCaptiveProbe(probe)
+		result.Probes = append(result.Probes, p)
+		passed = passed || p.Passed
+		if p.Intercepted {
+			result.Detected = true
+			if result.PortalURL == "" && p.Location != "" {
+				result.PortalURL = p.Location
+			}
+		}
+	}
+	if passed {
+		result.Detected = false
+		result.PortalURL = ""
+	}
+	return result
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDetectCaptivePortal(t *testing.T) {
+	for name, tc := range map[string]struct {
+		handler  http.HandlerFunc
+		detected bool
+	}{
+		"open": {func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}, false},
+		"redirect": {func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "http://portal.example/login", http.StatusFound)
+		}, true},
+		"substituted": {func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "<html>Please accept the terms of use</html>")
+		}, true},
+		"filtered": {func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `<iframe src="http://10.10.34.34?type=Invalid Site"></iframe>`)
+		}, false},
+	} {
+		server := httptest.NewServer(tc.handler)
+		t.Setenv("CAPTIVE_PORTAL_URLS", server.URL+"/generate_204")
+		result := detectCaptivePortal()
+		server.Close()
+
+		if result.Detected != tc.detected || len(result.Probes) != 1 {
+			t.Errorf("%s: %+v", name, result)
+		}
+	}
+}
+
+func TestCaptivePortalNeedsEveryProbeIntercepted(t *testing.T) {
+	open := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer open.Close()
+	odd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hello")
+	}))
+	defer odd.Close()
+
+	t.Setenv("CAPTIVE_PORTAL_URLS", odd.URL+","+open.URL)
+	if result := detectCaptivePortal(); result.Detected || !result.Probes[0].Intercepted {
+		t.Errorf("result = %+v", result)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Challenge kinds
+const (
+	ChallengeCookie       = "cookie"       // 403/429/503 that set cookies, retried once with them
+	ChallengeInterstitial = "interstitial" // JS/HTML browser check page we cannot pass
+)
+
+// ChallengeEvent records an anti-bot challenge met while talking to a host
+type ChallengeEvent struct {
+	Host           string   `json:"host"`
+	Kind           string   `json:"kind"`
+	Status         int      `json:"status"`
+	Cookies        []string `json:"cookies,omitempty"` // names only, values are never reported
+	Signature      string   `json:"signature,omitempty"`
+	Retried        bool     `json:"retried"`
+	RetrySucceeded bool     `json:"retry_succeeded"`
+	RetryStatus    int      `json:"retry_status,omitempty"`
+	RetryError     string   `json:"retry_error,omitempty"`
+	At             string   `json:"at"`
+}
+
+var challengeMu sync.Mutex
+
+func recordChallenge(event ChallengeEvent) {
+	challengeMu.Lock()
+	defer challengeMu.Unlock()
+	report.Challenges = append(report.Challenges, event)
+}
+
+func newChallengeEvent(host, kind string, resp *http.Response) *ChallengeEvent {
+	event := &ChallengeEvent{
+		Host:   host,
+		Kind:   kind,
+		Status: resp.StatusCode,
+		At:     time.Now().Format(time.RFC3339),
+	}
+	for _, cookie := range resp.Cookies() {
+		event.Cookies = append(event.Cookies, cookie.Name)
+	}
+	return event
+}
+
+// finishRetry fills in how the retry after a cookie challenge went
+func (e *ChallengeEvent) finishRetry(resp *http.Response, err error) {
+	e.Retried = true
+	if err != nil {
+		e.RetryError = err.Error()
+		return
+	}
+	e.RetryStatus = resp.StatusCode
+	e.RetrySucceeded = !isChallengeStatus(resp.StatusCode)
+}
+
+func isChallengeStatus(code int) bool {
+	return code == http.StatusForbidden || code == http.StatusServiceUnavailable || code == http.StatusTooManyRequests
+}
+
+// maxChallengePeek bounds how much of a suspicious body is buffered to look for challenge markers
+const maxChallengePeek = 64 << 10
+
+// detectInterstitial looks for a JS/HTML challenge page in resp without consuming its body.
+// It returns the matching signature ID, or "" when the response is not an interstitial.
+func detectInterstitial(resp *http.Response) string {
+	if resp == nil || resp.Body == nil || !isChallengeStatus(resp.StatusCode) {
+		return ""
+	}
+	if !strings.Contains(strings.ToLower(resp.Header.Get("Content-Type")), "html") {
+		return ""
+	}
+
+	peek, _ := io.ReadAll(io.LimitReader(resp.Body, maxChallengePeek))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(peek), resp.Body), resp.Body}
+
+	set := loadSignatures()
+	for i := range set.Signatures {
+		sig := &set.Signatures[i]
+		if sig.Category == CategoryChallenge && sig.matches(resp.StatusCode, "", string(peek)) {
+			return sig.ID
+		}
+	}
+	return ""
+}
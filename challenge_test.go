@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+)
+
+// serveShecanTLS routes every connection to handler over TLS, as if it were the real host
+func serveShecanTLS(t *testing.T, handler http.HandlerFunc) {
+	t.Helper()
+	cert, pool := newTestCertificate(t)
+	server := httptest.NewUnstartedServer(handler)
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	dialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", server.Listener.Addr().String())
+	}
+	tlsRootCAs = pool
+	report = Report{}
+	previousJar := sharedCookieJar
+	sharedCookieJar, _ = cookiejar.New(nil)
+	t.Cleanup(func() {
+		dialContext = nil
+		tlsRootCAs = nil
+		sharedCookieJar = previousJar
+	})
+}
+
+func TestCookieChallengeRecorded(t *testing.T) {
+	serveShecanTLS(t, func(w http.ResponseWriter, r *http.Request) {
+		if _, err := r.Cookie("sc_clearance"); err != nil {
+			http.SetCookie(w, &http.Cookie{Name: "sc_clearance", Value: "ok", Path: "/"})
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		io.WriteString(w, "welcome")
+	})
+
+	resp, err := HTTPRequest("https://check.shecan.ir/challenge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if len(report.Challenges) != 1 {
+		t.Fatalf("challenges = %+v", report.Challenges)
+	}
+	event := report.Challenges[0]
+	if event.Kind != ChallengeCookie || event.Status != http.StatusForbidden || !event.Retried || !event.RetrySucceeded ||
+		len(event.Cookies) != 1 || event.Cookies[0] != "sc_clearance" {
+		t.Errorf("event = %+v", event)
+	}
+}
+
+func TestInterstitialDetected(t *testing.T) {
+	const page = `<html><title>Just a moment...</title><script src="/cdn-cgi/challenge-platform/h/b"></script></html>`
+	serveShecanTLS(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, page)
+	})
+
+	resp, err := HTTPRequest("https://check.shecan.ir/interstitial")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != page {
+		t.Errorf("body was not preserved: %q", body)
+	}
+
+	if len(report.Challenges) != 1 || report.Challenges[0].Kind != ChallengeInterstitial || report.Challenges[0].Signature != "challenge-cloudflare" {
+		t.Errorf("challenges = %+v", report.Challenges)
+	}
+	if class := classifyResponse(resp, page); class.Category != CategoryChallenge {
+		t.Errorf("classification = %+v", class)
+	}
+}
+
+func TestPersistentJarRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cookies.json")
+	u, _ := url.Parse("https://check.shecan.ir/")
+
+	jar, err := newPersistentJar(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jar.SetCookies(u, []*http.Cookie{
+		{Name: "kept", Value: "1", Path: "/", MaxAge: 3600},
+		{Name: "session", Value: "2", Path: "/"},
+	})
+	if err := jar.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := newPersistentJar(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := reloaded.Cookies(u)
+	if len(cookies) != 1 || cookies[0].Name != "kept" || cookies[0].Value != "1" {
+		t.Errorf("reloaded cookies = %+v", cookies)
+	}
+}
@@ -4,15 +4,15 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"os/exec"
 	"runtime"
 	"strings"
+	"time"
 )
 
 func getDnsServer(plan Plan) []string {
 	fmt.Printf("\n%sFetching DNS servers for %s plan...\n", colorMap["blue"], plan.String())
 	// get the DNS server from shecan.ir/dns/{plan}.txt and return
-	url := fmt.Sprintf("https://shecan.ir/dns/%s.txt", strings.ToLower(plan.String()))
+	url := fmt.Sprintf("%s/dns/%s.txt", endpoints.Shecan, strings.ToLower(plan.String()))
 
 	resp, err := HTTPRequest(url)
 	if err != nil {
@@ -35,9 +35,13 @@ func getDnsServer(plan Plan) []string {
 func checkDNS(plan Plan) []string {
 	// get the DNS servers
 	dnsServers := getDnsServer(plan)
+	setTargetClass(dnsServers, TargetDNS)
 
 	fmt.Printf("\n%sChecking DNS servers...\n", colorMap["blue"])
+	performPortProbes(dnsServers)
 	runConcurrentPings(dnsServers, 4, 2)
+	// classify now: the run may stop before the Shecan IPs are probed
+	classifyReachability()
 
 	return dnsServers
 }
@@ -56,32 +60,32 @@ func disableIPv6() {
 }
 
 func disableIPv6Linux() {
-	cmd := exec.Command("sysctl", "-w", "net.ipv6.conf.all.disable_ipv6=1")
-	out, err := cmd.CombinedOutput()
+	result, err := runCommand(10*time.Second, "sysctl", "-w", "net.ipv6.conf.all.disable_ipv6=1")
+	out := result.Output()
 	if err != nil {
 		log.Println("Linux: Failed to disable IPv6:", err)
 	} else {
-		log.Println("Linux: IPv6 disabled:", string(out))
+		log.Println("Linux: IPv6 disabled:", out)
 	}
 }
 
 func disableIPv6Mac() {
 	// Replace "Wi-Fi" with your actual interface if needed
-	cmd := exec.Command("networksetup", "-setv6off", "Wi-Fi")
-	out, err := cmd.CombinedOutput()
+	result, err := runCommand(10*time.Second, "networksetup", "-setv6off", "Wi-Fi")
+	out := result.Output()
 	if err != nil {
 		log.Println("macOS: Failed to disable IPv6:", err)
 	} else {
-		log.Println("macOS: IPv6 disabled on Wi-Fi:", string(out))
+		log.Println("macOS: IPv6 disabled on Wi-Fi:", out)
 	}
 }
 
 func disableIPv6Windows() {
-	cmd := exec.Command("reg", "add", `HKLM\SYSTEM\CurrentControlSet\Services\Tcpip6\Parameters`, "/v", "DisabledComponents", "/t", "REG_DWORD", "/d", "0xffffffff", "/f")
-	out, err := cmd.CombinedOutput()
+	result, err := runCommand(10*time.Second, "reg", "add", `HKLM\SYSTEM\CurrentControlSet\Services\Tcpip6\Parameters`, "/v", "DisabledComponents", "/t", "REG_DWORD", "/d", "0xffffffff", "/f")
+	out := result.Output()
 	if err != nil {
 		log.Println("Windows: Failed to disable IPv6:", err)
 	} else {
-		log.Println("Windows: IPv6 disabled via registry (reboot needed):", string(out))
+		log.Println("Windows: IPv6 disabled via registry (reboot needed):", out)
 	}
 }
@@ -14,10 +14,17 @@ var (
 	checkShecanMu   sync.Mutex
 )
 
-func recordRequestResult(domain, value string) {
+func recordRequestResult(domain, value string, class Classification) {
 	requestResultMu.Lock()
 	defer requestResultMu.Unlock()
 	report.RequestResult[domain] = value
+	if report.RequestClassification == nil {
+		report.RequestClassification = make(map[string]Classification)
+	}
+	report.RequestClassification[domain] = class
+	if class.Category != CategoryOK {
+		fmt.Println(colorMap["yellow"], "[Warning]", domain, "classified as", class.Category, class.Signature)
+	}
 }
 
 func performShecanDomainChecks(domains []string) {
@@ -39,7 +46,7 @@ func performShecanDomainChecks(domains []string) {
 			response, err := HTTPRequest("https://"+d, "GET", "", "", "2")
 			if err != nil {
 				fmt.Println(colorMap["red"], "[Error] Can't Get", d)
-				recordRequestResult(d, fmt.Sprintf("Error: %v", err))
+				recordRequestResult(d, fmt.Sprintf("Error: %v", err), classifyError(err))
 				return
 			}
 			defer response.Body.Close()
@@ -47,12 +54,12 @@ func performShecanDomainChecks(domains []string) {
 			body, err := io.ReadAll(response.Body)
 			if err != nil {
 				fmt.Println(colorMap["red"], "[Error] Can't Read", d)
-				recordRequestResult(d, fmt.Sprintf("Error reading body: %v", err))
+				recordRequestResult(d, fmt.Sprintf("Error reading body: %v", err), classifyError(err))
 				return
 			}
 
 			fmt.Println(colorMap["blue"], "[INFO] Response:", string(body))
-			recordRequestResult(d, string(body))
+			recordRequestResult(d, string(body), classifyResponse(response, string(body)))
 		}(domain)
 	}
 
@@ -82,11 +89,14 @@ func performShecanOverIPChecks(ips []string) {
 			defer func() { <-sem }()
 
 			fmt.Println(colorMap["blue"], "[INFO] Checking Shecan Over IP:", target)
+			inspection := inspectTLS(target, "check.shecan.ir", DefaultConfig.Timeout)
+			reportTLSProblems(target, inspection)
+
 			response, err := HTTPRequest(fmt.Sprintf("https://%s", target), "GET", "", "Host: check.shecan.ir")
 			if err != nil {
 				fmt.Println(colorMap["red"], "[Error] Can't Get Check Shecan Result")
 				fmt.Println(colorMap["red"], err)
-				recordCheckShecanResult(target, CheckShecan{Error: fmt.Sprintf("Error: %v", err)})
+				recordCheckShecanResult(target, CheckShecan{Error: fmt.Sprintf("Error: %v", err), TLS: &inspection})
 				return
 			}
 			defer response.Body.Close()
@@ -95,12 +105,12 @@ func performShecanOverIPChecks(ips []string) {
 			body, err := io.ReadAll(response.Body)
 			if err != nil {
 				fmt.Println(colorMap["red"], "[Error] Can't Read Check Shecan Result")
-				recordCheckShecanResult(target, CheckShecan{Error: fmt.Sprintf("Error reading body: %v", err), Code: response.StatusCode})
+				recordCheckShecanResult(target, CheckShecan{Error: fmt.Sprintf("Error reading body: %v", err), Code: response.StatusCode, TLS: &inspection})
 				return
 			}
 
 			fmt.Println(colorMap["blue"], "[INFO] Check Shecan Result:", string(body))
-			recordCheckShecanResult(target, CheckShecan{Result: string(body), Code: response.StatusCode})
+			recordCheckShecanResult(target, CheckShecan{Result: string(body), Code: response.StatusCode, TLS: &inspection})
 		}(ip)
 	}
 
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Clock reference methods
+const (
+	ClockMethodHTTPDate = "http_date"
+	ClockMethodSNTP     = "sntp"
+)
+
+const clockTimeout = 5 * time.Second
+
+// TimeSample is one reference clock reading. Skew is local minus reference time.
+type TimeSample struct {
+	Source    string  `json:"source"`
+	Method    string  `json:"method"`
+	Reference string  `json:"reference,omitempty"`
+	SkewMs    float64 `json:"skew_ms"`
+	RTTMs     float64 `json:"rtt_ms,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// ClockInfo is the local clock compared with the reference clocks
+type ClockInfo struct {
+	Zone             string       `json:"zone"`
+	UTCOffset        string       `json:"utc_offset"`
+	Samples          []TimeSample `json:"samples"`
+	SkewSeconds      float64      `json:"skew_seconds"`
+	Known            bool         `json:"known"` // at least one reference answered
+	ThresholdSeconds float64      `json:"threshold_seconds"`
+	Exceeded         bool         `json:"exceeded"`
+}
+
+// clockSources returns CLOCK_SOURCES, or the Shecan endpoints whose Date headers we trust
+func clockSources() []string {
+	if sources := envList("CLOCK_SOURCES"); len(sources) > 0 {
+		return sources
+	}
+	return []string{endpoints.Shecan + "/", endpoints.Check + "/"}
+}
+
+// dateClient does not verify certificates: a clock far enough off to fail
+// verification is what the sample must still measure, and only the Date header is used
+func dateClient() *http.Client {
+	client := newHTTPClient(clockTimeout, "")
+	if transport, ok := client.Transport.(*http.Transport); ok {
+		transport = transport.Clone()
+		transport.TLSClientConfig.InsecureSkipVerify = true
+		client.Transport = transport
+	}
+	return client
+}
+
+// httpDateSample reads the Date header of url. The header has one second resolution,
+// so the reference is taken as the middle of that second at the middle of the round trip.
+func httpDateSample(url string) TimeSample {
+	sample := TimeSample{Source: url, Method: ClockMethodHTTPDate}
+	start := time.Now()
+	resp, err := dateClient().Get(url)
+	if err != nil {
+		sample.Error = err.Error()
+		return sample
+	}
+	resp.Body.Close()
+	rtt := time.Since(start)
+
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		sample.Error = "no usable Date header"
+		return sample
+	}
+	reference := date.Add(500 * time.Millisecond)
+	local := start.Add(rtt / 2)
+	sample.Reference = reference.UTC().Format(time.RFC3339)
+	sample.SkewMs = round2(float64(local.Sub(reference).Microseconds()) / 1000)
+	sample.RTTMs = round2(float64(rtt.Microseconds()) / 1000)
+	return sample
+}
+
+// ntpEpochOffset is the number of seconds between 1900 and 1970
+const ntpEpochOffset = 2208988800
+
+func ntpTime(b []byte) time.Time {
+	seconds := binary.BigEndian.Uint32(b)
+	fraction := binary.BigEndian.Uint32(b[4:])
+	nanos := (int64(fraction) * 1e9) >> 32
+	return time.Unix(int64(seconds)-ntpEpochOffset, nanos)
+}
+
+// sntpSample asks server, "host" or "host:port", for the time with a single SNTPv4 request
+func sntpSample(server string) TimeSample {
+	sample := TimeSample{Source: server, Method: ClockMethodSNTP}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "123")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), clockTimeout)
+	defer cancel()
+	conn, err := dialerFor(clockTimeout)(ctx, "udp", server)
+	if err != nil {
+		sample.Error = err.Error()
+		return sample
+	}
+	defer conn.Close()
+
+	request := make([]byte, 48)
+	request[0] = 0x23 // LI 0, version 4, mode 3 (client)
+	t1 := time.Now()
+	conn.SetDeadline(t1.Add(clockTimeout))
+	if _, err := conn.Write(request); err != nil {
+		sample.Error = err.Error()
+		return sample
+	}
+	response := make([]byte, 48)
+	n, err := conn.Read(response)
+	t4 := time.Now()
+	if err != nil {
+		sample.Error = err.Error()
+		return sample
+	}
+	if n < 48 || response[0]&0x07 != 4 || response[1] == 0 {
+		sample.Error = "invalid SNTP response"
+		return sample
+	}
+
+	// offset = ((t2 - t1) + (t3 - t4)) / 2, the skew is its negation
+	t2, t3 := ntpTime(response[32:]), ntpTime(response[40:])
+	offset := (t2.Sub(t1) + t3.Sub(t4)) / 2
+	sample.Reference = t4.Add(offset).UTC().Format(time.RFC3339)
+	sample.SkewMs = round2(float64(-offset.Microseconds()) / 1000)
+	sample.RTTMs = round2(float64((t4.Sub(t1) - t3.Sub(t2)).Microseconds()) / 1000)
+	return sample
+}
+
+// checkClock compares the local clock with the HTTP Date headers of the clock sources and,
+// when SNTP_SERVER is set, an SNTP server. SNTP is preferred as it is far more precise.
+func checkClock() *ClockInfo {
+	now := time.Now()
+	zone, _ := now.Zone()
+	info := &ClockInfo{
+		Zone:             zone,
+		UTCOffset:        now.Format("-07:00"),
+		ThresholdSeconds: envDuration("CLOCK_SKEW_THRESHOLD", time.Minute).Seconds(),
+	}
+
+	for _, source := range clockSources() {
+		info.Samples = append(info.Samples, httpDateSample(source))
+	}
+	if server := envString("SNTP_SERVER", ""); server != "" {
+		info.Samples = append(info.Samples, sntpSample(server))
+	}
+
+	skew, err := combineSkew(info.Samples)
+	if err == nil {
+		info.Known = true
+		info.SkewSeconds = round2(skew.Seconds())
+		info.Exceeded = math.Abs(info.SkewSeconds) > info.ThresholdSeconds
+	}
+	return info
+}
+
+// combineSkew takes the SNTP skew if there is one, otherwise the median of the HTTP samples
+func combineSkew(samples []TimeSample) (time.Duration, error) {
+	var skews []float64
+	for _, s := range samples {
+		if s.Error != "" {
+			continue
+		}
+		if s.Method == ClockMethodSNTP {
+			return time.Duration(s.SkewMs * float64(time.Millisecond)), nil
+		}
+		skews = append(skews, s.SkewMs)
+	}
+	if len(skews) == 0 {
+		return 0, errors.New("no reference clock answered")
+	}
+	sort.Float64s(skews)
+	median := skews[len(skews)/2]
+	if len(skews)%2 == 0 {
+		median = (skews[len(skews)/2-1] + median) / 2
+	}
+	return time.Duration(median * float64(time.Millisecond)), nil
+}
+
+// recordClock fills the report's time fields and warns about a skewed clock
+func recordClock(r *Report, info *ClockInfo) {
+	now := time.Now()
+	r.Clock = info
+	r.LocalTime = now.Format(time.RFC3339) + " " + info.Zone
+	if info.Known {
+		r.RealTime = now.Add(-time.Duration(info.SkewSeconds * float64(time.Second))).UTC().Format(time.RFC3339)
+	}
+	if info.Exceeded {
+		fmt.Printf("%s [Warning] Your clock is off by %.0f seconds, TLS checks may fail\n", colorMap["yellow"], info.SkewSeconds)
+	}
+}
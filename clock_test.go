@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// serveSNTP answers one SNTP request as a server whose clock is offset from ours
+func serveSNTP(t *testing.T, offset time.Duration) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	putTime := func(b []byte, tm time.Time) {
+		binary.BigEndian.PutUint32(b, uint32(tm.Unix()+ntpEpochOffset))
+		binary.BigEndian.PutUint32(b[4:], uint32((int64(tm.Nanosecond())<<32)/1e9))
+	}
+	go func() {
+		buf := make([]byte, 48)
+		_, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		response := make([]byte, 48)
+		response[0] = 0x24 // version 4, mode 4 (server)
+		response[1] = 2    // stratum
+		putTime(response[32:], time.Now().Add(offset))
+		putTime(response[40:], time.Now().Add(offset))
+		conn.WriteTo(response, from)
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestSNTPSample(t *testing.T) {
+	sample := sntpSample(serveSNTP(t, -90*time.Second))
+	if sample.Error != "" || math.Abs(sample.SkewMs-90000) > 50 {
+		t.Fatalf("sample = %+v", sample)
+	}
+}
+
+func TestHTTPDateSampleExpiredCertificate(t *testing.T) {
+	// from a clock a year ahead the server's certificate has expired
+	cert, pool := newTestCertificateValid(t, time.Now().Add(-48*time.Hour), time.Now().Add(-24*time.Hour))
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(-365*24*time.Hour).UTC().Format(http.TimeFormat))
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	server.StartTLS()
+	defer server.Close()
+	tlsRootCAs = pool
+	t.Cleanup(func() { tlsRootCAs = nil })
+
+	if _, err := newHTTPClient(clockTimeout, "").Get(server.URL); err == nil {
+		t.Fatal("expired certificate accepted by the normal client")
+	}
+	sample := httpDateSample(server.URL)
+	if sample.Error != "" || math.Abs(sample.SkewMs/1000-365*24*3600) > 2 {
+		t.Fatalf("sample = %+v", sample)
+	}
+}
+
+func TestCheckClock(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(-10*time.Minute).UTC().Format(http.TimeFormat))
+	}))
+	defer server.Close()
+	t.Setenv("CLOCK_SOURCES", server.URL+"/,http://127.0.0.1:1/")
+
+	info := checkClock()
+	if !info.Known || !info.Exceeded || math.Abs(info.SkewSeconds-600) > 2 || len(info.Samples) != 2 || info.Samples[1].Error == "" {
+		t.Fatalf("clock = %+v", info)
+	}
+
+	// SNTP wins over the coarse Date headers
+	t.Setenv("SNTP_SERVER", serveSNTP(t, 5*time.Second))
+	info = checkClock()
+	if !info.Known || info.Exceeded || math.Abs(info.SkewSeconds+5) > 0.1 {
+		t.Fatalf("clock with SNTP = %+v", info)
+	}
+
+	var r Report
+	recordClock(&r, info)
+	if r.LocalTime == "" || r.RealTime == "" {
+		t.Errorf("report times = %q, %q", r.LocalTime, r.RealTime)
+	}
+}
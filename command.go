@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CommandResult is the outcome of running an external command
+type CommandResult struct {
+	Command    string   `json:"command"`
+	Args       []string `json:"args"`
+	Stdout     string   `json:"-"`
+	Stderr     string   `json:"stderr,omitempty"`
+	ExitCode   int      `json:"exit_code"`
+	DurationMs int64    `json:"duration_ms"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// Output returns stdout followed by stderr, the way a terminal would show them
+func (r CommandResult) Output() string {
+	return r.Stdout + r.Stderr
+}
+
+// CommandRunner runs external commands without a shell
+type CommandRunner interface {
+	Run(ctx context.Context, name string, args ...string) (CommandResult, error)
+}
+
+// execRunner runs commands with os/exec
+type execRunner struct{}
+
+// Run implements CommandRunner
+func (execRunner) Run(ctx context.Context, name string, args ...string) (CommandResult, error) {
+	result := CommandResult{Command: name, Args: args}
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	result.ExitCode = 0
+	if err != nil {
+		result.ExitCode = -1
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			result.ExitCode = exitErr.ExitCode()
+		}
+	}
+	return result, err
+}
+
+// commandRunner executes every external command; tests replace it with a scripted fake
+var commandRunner CommandRunner = execRunner{}
+
+var (
+	commandLogMu sync.Mutex
+	commandLog   []CommandResult
+)
+
+// runCommand runs name with a timeout through commandRunner and records it for the report
+func runCommand(timeout time.Duration, name string, args ...string) (CommandResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	result, err := commandRunner.Run(ctx, name, args...)
+	result.Command, result.Args = name, args
+	result.DurationMs = time.Since(start).Milliseconds()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = errCommandTimeout
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	result.Stderr = strings.TrimSpace(result.Stderr)
+
+	commandLogMu.Lock()
+	commandLog = append(commandLog, result)
+	commandLogMu.Unlock()
+
+	return result, err
+}
+
+var errCommandTimeout = errors.New("command timed out")
+
+// resetCommandLog forgets the commands recorded by a previous run
+func resetCommandLog() {
+	commandLogMu.Lock()
+	commandLog = nil
+	commandLogMu.Unlock()
+}
+
+// commandHistory returns a copy of every command run so far
+func commandHistory() []CommandResult {
+	commandLogMu.Lock()
+	defer commandLogMu.Unlock()
+	return append([]CommandResult(nil), commandLog...)
+}
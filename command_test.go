@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// scriptedRunner replays recorded command output instead of executing anything.
+// Results are looked up by the full command line first, then by command name.
+type scriptedRunner struct {
+	mu      sync.Mutex
+	scripts map[string]CommandResult
+	calls   []string
+}
+
+func newScriptedRunner(scripts map[string]CommandResult) *scriptedRunner {
+	return &scriptedRunner{scripts: scripts}
+}
+
+func (r *scriptedRunner) Run(ctx context.Context, name string, args ...string) (CommandResult, error) {
+	line := strings.Join(append([]string{name}, args...), " ")
+	r.mu.Lock()
+	r.calls = append(r.calls, line)
+	result, ok := r.scripts[line]
+	if !ok {
+		result, ok = r.scripts[name]
+	}
+	r.mu.Unlock()
+
+	if !ok {
+		return CommandResult{ExitCode: 127}, errors.New("executable file not found in $PATH")
+	}
+	if result.ExitCode != 0 {
+		return result, errors.New("exit status " + strings.TrimSpace(result.Stderr))
+	}
+	return result, nil
+}
+
+// useScriptedRunner installs r as the command runner for the duration of the test
+func useScriptedRunner(t *testing.T, r *scriptedRunner) {
+	t.Helper()
+	commandRunner = r
+	resetCommandLog()
+	t.Cleanup(func() { commandRunner = execRunner{} })
+}
+
+const recordedLinuxPing = `PING 178.22.122.100 (178.22.122.100) 56(84) bytes of data.
+64 bytes from 178.22.122.100: icmp_seq=1 ttl=57 time=21.4 ms
+64 bytes from 178.22.122.100: icmp_seq=2 ttl=57 time=23.0 ms
+
+--- 178.22.122.100 ping statistics ---
+2 packets transmitted, 2 received, 0% packet loss, time 1001ms
+rtt min/avg/max/mdev = 21.412/22.206/23.001/0.794 ms
+`
+
+const recordedMacPing = `PING 185.51.200.2 (185.51.200.2): 56 data bytes
+64 bytes from 185.51.200.2: icmp_seq=0 ttl=56 time=30.118 ms
+64 bytes from 185.51.200.2: icmp_seq=1 ttl=56 time=31.902 ms
+
+--- 185.51.200.2 ping statistics ---
+2 packets transmitted, 2 packets received, 0.0% packet loss
+round-trip min/avg/max/stddev = 30.118/31.010/31.902/0.892 ms
+`
+
+const recordedNslookup = `Server:		178.22.122.100
+Address:	178.22.122.100#53
+
+Non-authoritative answer:
+Name:	check.shecan.ir
+Address: 185.51.200.1
+`
+
+func TestRunCommandRecordsHistory(t *testing.T) {
+	useScriptedRunner(t, newScriptedRunner(map[string]CommandResult{
+		"nslookup check.shecan.ir": {Stdout: recordedNslookup},
+	}))
+
+	out, err := RunCommand(time.Second, "nslookup", "check.shecan.ir")
+	if err != nil || out != recordedNslookup {
+		t.Fatalf("RunCommand = %q, %v", out, err)
+	}
+	if _, err := RunCommand(time.Second, "dig", "check.shecan.ir"); err == nil {
+		t.Fatal("expected unscripted command to fail")
+	}
+
+	history := commandHistory()
+	if len(history) != 2 {
+		t.Fatalf("history has %d entries", len(history))
+	}
+	if history[0].Command != "nslookup" || history[0].ExitCode != 0 || history[0].Error != "" {
+		t.Errorf("first entry = %+v", history[0])
+	}
+	if history[1].ExitCode != 127 || history[1].Error == "" {
+		t.Errorf("second entry = %+v", history[1])
+	}
+}
+
+func TestNsLookupReplay(t *testing.T) {
+	useScriptedRunner(t, newScriptedRunner(map[string]CommandResult{
+		"nslookup": {Stdout: recordedNslookup},
+	}))
+
+	records := NsLookup("check.shecan.ir")
+	if len(records) != 1 || records[0].Value != "185.51.200.1" || records[0].Resolver != "178.22.122.100" {
+		t.Fatalf("records = %+v", records)
+	}
+}
+
+func TestParseNslookupOutput(t *testing.T) {
+	windows := "Server:  UnKnown\r\nAddress:  178.22.122.100\r\n\r\nNon-authoritative answer:\r\nName:    check.shecan.ir\r\nAddress:  185.51.200.1\r\n"
+	for name, output := range map[string]string{"unix": recordedNslookup, "windows": windows} {
+		records, err := ParseNslookupOutput(output, "check.shecan.ir")
+		if err != nil || len(records) != 1 || records[0].Value != "185.51.200.1" {
+			t.Errorf("%s: records = %+v, %v", name, records, err)
+		}
+	}
+
+	// the resolver's own address is not an answer
+	if records, err := ParseNslookupOutput("Server:  UnKnown\r\nAddress:  178.22.122.100\r\n\r\n*** UnKnown can't find fail.shecan.ir: Non-existent domain\r\n", "fail.shecan.ir"); err == nil {
+		t.Errorf("records = %+v", records)
+	}
+}
+
+func TestPingReplay(t *testing.T) {
+	for name, output := range map[string]string{"linux": recordedLinuxPing, "darwin": recordedMacPing} {
+		useScriptedRunner(t, newScriptedRunner(map[string]CommandResult{"ping": {Stdout: output}}))
+		result, err := binaryPing("178.22.122.100", 2, 1)
+		if err != nil || result.Received != 2 || len(result.LostSeqs) != 0 || result.TTL == 0 || newPingStats(result).avg() <= 0 {
+			t.Errorf("%s: binaryPing = %+v, %v", name, result, err)
+		}
+	}
+}
+
+func TestParseWindowsDNSServers(t *testing.T) {
+	output := "178.22.122.100\r\n185.51.200.2\r\n2001:4860:4860::8888\r\nfec0:0:0:ffff::1\r\nfec0:0:0:ffff::2\r\n\r\n"
+	got := parseWindowsDNSServers(output)
+	if strings.Join(got, ",") != "178.22.122.100,185.51.200.2,2001:4860:4860::8888" {
+		t.Errorf("parseWindowsDNSServers = %v", got)
+	}
+}
+
+func TestExtractAvgRTT(t *testing.T) {
+	for name, tc := range map[string]struct {
+		output string
+		avg    float64
+	}{
+		"linux mdev":   {recordedLinuxPing, 22.206},
+		"macos stddev": {recordedMacPing, 31.010},
+		"windows":      {"    Minimum = 20ms, Maximum = 24ms, Average = 22ms\r\n", 22},
+		"single reply": {"rtt min/avg/max/mdev = 21.412/21.412/21.412/nan ms\n", 21.412},
+	} {
+		if avg, err := extractAvgRTT(tc.output); err != nil || avg != tc.avg {
+			t.Errorf("%s: extractAvgRTT = %v, %v", name, avg, err)
+		}
+	}
+	if _, err := extractAvgRTT("2 packets transmitted, 0 received, 100% packet loss\n"); err == nil {
+		t.Error("expected an error without a summary line")
+	}
+}
+
+func TestParseResolvConf(t *testing.T) {
+	got := parseResolvConf("# generated\nnameserver 178.22.122.100\nsearch lan\nnameserver 185.51.200.2\n")
+	if strings.Join(got, ",") != "178.22.122.100,185.51.200.2" {
+		t.Errorf("parseResolvConf = %v", got)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// storedCookie is a cookie saved to disk together with the URL that set it
+type storedCookie struct {
+	URL      string    `json:"url"`
+	Name     string    `json:"name"`
+	Value    string    `json:"value"`
+	Path     string    `json:"path,omitempty"`
+	Domain   string    `json:"domain,omitempty"`
+	Expires  time.Time `json:"expires"`
+	Secure   bool      `json:"secure,omitempty"`
+	HttpOnly bool      `json:"http_only,omitempty"`
+}
+
+// persistentJar is a cookiejar.Jar that remembers persistent cookies so they can be saved.
+// Session cookies are kept in memory only, like a browser would.
+type persistentJar struct {
+	*cookiejar.Jar
+	path string
+
+	mu     sync.Mutex
+	stored map[string]storedCookie // keyed by domain, path and name
+}
+
+func newPersistentJar(path string) (*persistentJar, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	j := &persistentJar{Jar: jar, path: path, stored: map[string]storedCookie{}}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var saved []storedCookie
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, err
+	}
+	for _, c := range saved {
+		u, err := url.Parse(c.URL)
+		if err != nil || time.Now().After(c.Expires) {
+			continue
+		}
+		j.SetCookies(u, []*http.Cookie{{
+			Name: c.Name, Value: c.Value, Path: c.Path, Domain: c.Domain,
+			Expires: c.Expires, Secure: c.Secure, HttpOnly: c.HttpOnly,
+		}})
+	}
+	return j, nil
+}
+
+// SetCookies implements http.CookieJar
+func (j *persistentJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.Jar.SetCookies(u, cookies)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, c := range cookies {
+		expires := c.Expires
+		if c.MaxAge > 0 {
+			expires = time.Now().Add(time.Duration(c.MaxAge) * time.Second)
+		}
+		domain := c.Domain
+		if domain == "" {
+			domain = u.Hostname()
+		}
+		key := domain + "|" + c.Path + "|" + c.Name
+		if c.MaxAge < 0 || (!expires.IsZero() && time.Now().After(expires)) {
+			delete(j.stored, key)
+			continue
+		}
+		if expires.IsZero() {
+			continue
+		}
+		j.stored[key] = storedCookie{
+			URL:  (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/"}).String(),
+			Name: c.Name, Value: c.Value, Path: c.Path, Domain: c.Domain,
+			Expires: expires, Secure: c.Secure, HttpOnly: c.HttpOnly,
+		}
+	}
+}
+
+// Save writes the persistent cookies to the jar file
+func (j *persistentJar) Save() error {
+	j.mu.Lock()
+	saved := make([]storedCookie, 0, len(j.stored))
+	for _, c := range j.stored {
+		if time.Now().Before(c.Expires) {
+			saved = append(saved, c)
+		}
+	}
+	j.mu.Unlock()
+
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(j.path, data, 0o600)
+}
+
+// setupCookieJar swaps the shared jar for one backed by COOKIE_JAR_FILE, if configured
+func setupCookieJar() {
+	path := os.Getenv("COOKIE_JAR_FILE")
+	if path == "" {
+		return
+	}
+	jar, err := newPersistentJar(path)
+	if err != nil {
+		fmt.Println(colorMap["yellow"], "[Warning] Can't load cookie jar, using an in-memory one:", err)
+		return
+	}
+	sharedCookieJar = jar
+}
+
+// saveCookieJar persists the shared jar when it is file backed
+func saveCookieJar() {
+	jar, ok := sharedCookieJar.(*persistentJar)
+	if !ok {
+		return
+	}
+	if err := jar.Save(); err != nil {
+		fmt.Println(colorMap["yellow"], "[Warning] Can't save cookie jar:", err)
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testUpdaterLink = "https://ddns.shecan.ir/update?password=0123456789abcdef"
+
+// fakeShecan is an httptest server answering for every Shecan host the diagnostic talks to.
+// Hosts listed in down refuse connections, the way fail.shecan.ir does behind Shecan.
+type fakeShecan struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu       sync.Mutex
+	hits     map[string]int
+	reports  []Report
+	down     map[string]bool
+	updater  string
+	portal   bool
+	check403 int // number of 403s check.shecan.ir answers before it is ready
+}
+
+func newFakeShecan(t *testing.T) *fakeShecan {
+	f := &fakeShecan{
+		t:       t,
+		hits:    map[string]int{},
+		down:    map[string]bool{"fail.shecan.ir": true},
+		updater: "OK",
+	}
+
+	cert, pool := newTestCertificate(t)
+	f.server = httptest.NewUnstartedServer(http.HandlerFunc(f.handle))
+	f.server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	f.server.StartTLS()
+	t.Cleanup(f.server.Close)
+
+	dialContext = f.dial
+	tlsRootCAs = pool
+	lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		return []net.IPAddr{{IP: net.ParseIP("10.0.0.1")}}, nil
+	}
+	t.Cleanup(func() {
+		dialContext = nil
+		tlsRootCAs = nil
+		lookupIPAddr = net.DefaultResolver.LookupIPAddr
+	})
+
+	useScriptedRunner(t, newScriptedRunner(map[string]CommandResult{
+		"ping":       {Stdout: recordedLinuxPing},
+		"nslookup":   {Stdout: recordedNslookup},
+		"traceroute": {Stdout: recordedTraceroute},
+	}))
+
+	services := filepath.Join(t.TempDir(), "services.json")
+	if err := os.WriteFile(services, []byte(`{"version":1,"services":[{"name":"Service","url":"https://service.test/","expect":{"status":[200]}}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SERVICE_TARGETS", services)
+	t.Setenv("REPORT_SERVER_URL", "https://report.test/report")
+	t.Setenv("PING_ENGINE", PingMethodBinary)
+	t.Setenv("PROPAGATION_POLL_INTERVAL", "10ms")
+	t.Setenv("PROPAGATION_MAX_WAIT", "5s")
+	t.Setenv("TRACE_ROUNDS", "3")
+	t.Setenv("CLOCK_SOURCES", "https://shecan.ir/")
+	t.Setenv("CAPTIVE_PORTAL_URLS", "https://connectivity.test/generate_204")
+
+	return f
+}
+
+func (f *fakeShecan) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, _ := net.SplitHostPort(addr)
+	f.mu.Lock()
+	down := f.down[host]
+	f.mu.Unlock()
+	if down || network == "udp" {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: errors.New("connection refused")}
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", f.server.Listener.Addr().String())
+}
+
+func (f *fakeShecan) handle(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	f.mu.Lock()
+	f.hits[host+r.URL.Path]++
+	checkHits := f.hits["check.shecan.ir/"]
+	f.mu.Unlock()
+
+	switch {
+	case host == "connectivity.test" && f.portal:
+		http.Redirect(w, r, "http://login.hotel.test/", http.StatusFound)
+	case host == "connectivity.test":
+		w.WriteHeader(http.StatusNoContent)
+	case host == "shecan.ir" && r.URL.Path == "/ip/":
+		fmt.Fprint(w, "192.0.2.10")
+	case host == "shecan.ir" && strings.HasPrefix(r.URL.Path, "/dns/"):
+		fmt.Fprint(w, "127.0.0.1\n")
+	case host == "ddns.shecan.ir":
+		fmt.Fprint(w, f.updater)
+	case host == "check.shecan.ir" && r.URL.Path == "/ip-list.php":
+		fmt.Fprint(w, "10.0.0.1\n10.0.0.2\n")
+	case host == "check.shecan.ir":
+		if checkHits <= f.check403 {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, "Shecan is working")
+	case host == "fail.shecan.ir":
+		fmt.Fprint(w, "not behind Shecan")
+	case host == "service.test":
+		fmt.Fprint(w, "welcome")
+	case host == "report.test":
+		var received Report
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			f.t.Errorf("report decode: %v", err)
+		}
+		f.mu.Lock()
+		f.reports = append(f.reports, received)
+		f.mu.Unlock()
+		fmt.Fprint(w, "saved")
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeShecan) hitCount(key string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.hits[key]
+}
+
+func (f *fakeShecan) received() []Report {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Report(nil), f.reports...)
+}
+
+// newTestCertificate issues a self-signed certificate valid for every host the fake serves
+func newTestCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	return newTestCertificateValid(t, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+}
+
+// newTestCertificateValid issues the fake's certificate for the given validity period
+func newTestCertificateValid(t *testing.T, notBefore, notAfter time.Time) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fake shecan"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"shecan.ir", "*.shecan.ir", "service.test", "report.test", "connectivity.test"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+func runWithInput(t *testing.T, plan, input string) {
+	t.Helper()
+	PlanFlag = plan
+	stdin = strings.NewReader(input)
+	t.Cleanup(func() {
+		PlanFlag = ""
+		stdin = os.Stdin
+	})
+	runDiagnostic()
+}
+
+func TestRunDiagnosticSuccess(t *testing.T) {
+	f := newFakeShecan(t)
+	runWithInput(t, "Pro", testUpdaterLink+"\n")
+
+	reports := f.received()
+	if len(reports) != 1 {
+		t.Fatalf("expected one report, got %d", len(reports))
+	}
+	got := reports[0]
+	if got.Plan != Pro || got.UpdaterLink != testUpdaterLink {
+		t.Errorf("unexpected plan/updater: %v %q", got.Plan, got.UpdaterLink)
+	}
+	if got.RequestResult["check.shecan.ir"] != "Shecan is working" {
+		t.Errorf("check.shecan.ir result = %q", got.RequestResult["check.shecan.ir"])
+	}
+	if !strings.Contains(got.RequestResult["fail.shecan.ir"], "Error") {
+		t.Errorf("fail.shecan.ir should fail, got %q", got.RequestResult["fail.shecan.ir"])
+	}
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2"} {
+		result, ok := got.CheckShecanResult[ip]
+		if !ok || result.Code != http.StatusOK || result.TLS == nil || !result.TLS.Verified {
+			t.Errorf("over-IP result for %s = %+v", ip, result)
+		}
+	}
+	if len(got.ServiceChecks) != 1 || got.ServiceChecks[0].Outcome != ServiceUnblocked || got.ServiceChecks[0].Steering != SteeringShecan {
+		t.Errorf("service checks = %+v", got.ServiceChecks)
+	}
+	if got.Propagation == nil || got.Propagation.Status != PropagationDone || got.Propagation.Checks != 1 {
+		t.Errorf("propagation = %+v", got.Propagation)
+	}
+	if mtu := got.PathMTU["10.0.0.1"]; mtu.PathMTU < minPathMTU || mtu.Method != PingMethodBinary || mtu.Mismatch {
+		t.Errorf("path MTU = %+v", mtu)
+	}
+	health := map[string]string{}
+	for _, check := range got.Health {
+		health[check.Target+" "+check.Metric] = check.Severity
+	}
+	// the recorded ping answers two of four probes and the fake refuses UDP
+	for key, severity := range map[string]string{
+		"127.0.0.1 rtt_ms":         SeverityHealthy,
+		"127.0.0.1 loss_pct":       SeverityFailed,
+		"127.0.0.1 dns_latency_ms": SeverityFailed,
+		"10.0.0.1 http_status":     SeverityHealthy,
+		"Service http_status":      SeverityHealthy,
+	} {
+		if health[key] != severity {
+			t.Errorf("health %s = %q, want %q", key, health[key], severity)
+		}
+	}
+	if got.CaptivePortal == nil || got.CaptivePortal.Detected {
+		t.Errorf("captive portal = %+v", got.CaptivePortal)
+	}
+	if got.Clock == nil || !got.Clock.Known || got.Clock.Exceeded || got.LocalTime == "" || got.RealTime == "" {
+		t.Errorf("clock = %+v, local %q, real %q", got.Clock, got.LocalTime, got.RealTime)
+	}
+	if got.PublicIP != "192.0.2.10" || got.PublicIPs == nil || got.PublicIPs.Disagreement {
+		t.Errorf("public IPs = %+v", got.PublicIPs)
+	}
+	if got.Network == nil || len(got.Network.Interfaces) == 0 {
+		t.Errorf("network = %+v", got.Network)
+	}
+	if len(got.Traces) != 3 || len(got.Traces[0].Hops) != 4 || got.Traces[0].Method != PingMethodBinary {
+		t.Errorf("traces = %+v", got.Traces)
+	}
+	if stats, ok := got.PingStats["127.0.0.1"]; !ok || stats.Received != 2 || got.PingReports["127.0.0.1"] != "22.20 ms" {
+		t.Errorf("ping stats = %+v, legacy = %q", stats, got.PingReports["127.0.0.1"])
+	}
+	if r := got.Reachability["10.0.0.1"]; r.Classification != ReachabilityServiceOnly || len(r.Probes) != 4 {
+		t.Errorf("reachability = %+v", r)
+	}
+	if r := got.Reachability["127.0.0.1"]; r.Classification != ReachabilityOK {
+		t.Errorf("DNS server reachability = %+v", r)
+	}
+	if len(got.Commands) == 0 {
+		t.Error("commands were not recorded in the report")
+	}
+}
+
+func TestRunDiagnosticLeak(t *testing.T) {
+	f := newFakeShecan(t)
+	f.down = map[string]bool{}
+	runWithInput(t, "Free", "")
+
+	if n := len(f.received()); n != 0 {
+		t.Errorf("expected no report when fail.shecan.ir is reachable, got %d", n)
+	}
+	if f.hitCount("fail.shecan.ir/") == 0 {
+		t.Error("fail.shecan.ir was never checked")
+	}
+	if f.hitCount("check.shecan.ir/ip-list.php") != 0 {
+		t.Error("ip-list should not be fetched after a leak")
+	}
+	if findings := diagnose(&report); len(findings) == 0 || !strings.HasPrefix(findings[0].ID, "dns-") {
+		t.Errorf("findings = %+v", findings)
+	}
+}
+
+func TestRunDiagnosticCaptivePortal(t *testing.T) {
+	f := newFakeShecan(t)
+	f.portal = true
+	runWithInput(t, "Pro", testUpdaterLink+"\n")
+
+	if f.hitCount("shecan.ir/dns/pro.txt") != 0 || f.hitCount("ddns.shecan.ir/update") != 0 {
+		t.Error("checks ran behind a captive portal")
+	}
+	// the portal would answer these in place of the real network
+	if f.hitCount("shecan.ir/ip/") != 0 || f.hitCount("shecan.ir/") != 0 || report.PublicIPs != nil || report.Clock != nil {
+		t.Errorf("probes ran before the captive portal check: public IPs %+v, clock %+v", report.PublicIPs, report.Clock)
+	}
+	if n := len(f.received()); n != 0 {
+		t.Errorf("expected no report behind a captive portal, got %d", n)
+	}
+	if report.CaptivePortal == nil || report.CaptivePortal.PortalURL != "http://login.hotel.test/" {
+		t.Errorf("captive portal = %+v", report.CaptivePortal)
+	}
+	if findings := diagnose(&report); len(findings) != 1 || findings[0].ID != "captive-portal" {
+		t.Errorf("findings = %+v", findings)
+	}
+}
+
+func TestRunDiagnosticNoHost(t *testing.T) {
+	f := newFakeShecan(t)
+	f.updater = "nohost"
+	runWithInput(t, "Pro", testUpdaterLink+"\n")
+
+	if f.hitCount("ddns.shecan.ir/update") != 1 {
+		t.Errorf("updater hits = %d", f.hitCount("ddns.shecan.ir/update"))
+	}
+	if f.hitCount("check.shecan.ir/") != 0 {
+		t.Error("check.shecan.ir should not be polled after nohost")
+	}
+	if n := len(f.received()); n != 0 {
+		t.Errorf("expected no report after nohost, got %d", n)
+	}
+	if findings := diagnose(&report); len(findings) == 0 || findings[0].ID != "ddns-nohost" {
+		t.Errorf("findings = %+v", findings)
+	}
+}
+
+func TestRunDiagnosticWaitsFor403(t *testing.T) {
+	f := newFakeShecan(t)
+	f.check403 = 2
+	runWithInput(t, "Pro", testUpdaterLink+"\n")
+
+	reports := f.received()
+	if len(reports) != 1 {
+		t.Fatalf("expected one report, got %d", len(reports))
+	}
+	propagation := reports[0].Propagation
+	if propagation == nil || propagation.Status != PropagationDone || propagation.Checks != 3 || propagation.LastCode != http.StatusOK {
+		t.Errorf("propagation = %+v", propagation)
+	}
+}
@@ -0,0 +1,525 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// Finding severities, from most to least urgent
+const (
+	FindingCritical = "critical"
+	FindingWarning  = "warning"
+	FindingInfo     = "info"
+)
+
+// Finding is one conclusion drawn from the report. IDs are stable so the report server
+// and support can refer to them.
+type Finding struct {
+	ID          string   `json:"id"`
+	Severity    string   `json:"severity"`
+	Title       string   `json:"title"`
+	Evidence    []string `json:"evidence,omitempty"`
+	Remediation string   `json:"remediation"`
+}
+
+// findingRules are evaluated in order; within a severity findings keep this order
+var findingRules = []func(r *Report) *Finding{
+	findCaptivePortal,
+	findUpdaterResponse,
+	findDDNSMismatch,
+	findNoShecanDNS,
+	findHostsOverride,
+	findDNSLeak,
+	findVPNActive,
+	findDNSRedirect,
+	findShecanUnreachable,
+	findClockSkew,
+	findTLSInterception,
+	findIPv6DNSLeak,
+	findPublicIPDisagreement,
+	findOverIPFailures,
+	findUnreachableDNSServers,
+	findPropagationTimeout,
+	findHighLatency,
+	findBlockedServices,
+	findMTUMismatch,
+	findICMPBlocked,
+	findProxyActive,
+}
+
+// diagnose runs every finding rule over r and ranks the findings by severity
+func diagnose(r *Report) []Finding {
+	findings := []Finding{}
+	for _, rule := range findingRules {
+		if f := rule(r); f != nil {
+			findings = append(findings, *f)
+		}
+	}
+	rank := map[string]int{FindingCritical: 0, FindingWarning: 1, FindingInfo: 2}
+	sort.SliceStable(findings, func(i, j int) bool {
+		return rank[findings[i].Severity] < rank[findings[j].Severity]
+	})
+	return findings
+}
+
+func printFindings(findings []Finding) {
+	fmt.Println(colorMap["blue"], "[INFO] Findings:")
+	if len(findings) == 0 {
+		fmt.Println(colorMap["green"], "No problems found")
+		return
+	}
+	for i, f := range findings {
+		color := colorMap["grey"]
+		switch f.Severity {
+		case FindingCritical:
+			color = colorMap["red"]
+		case FindingWarning:
+			color = colorMap["yellow"]
+		}
+		fmt.Printf("%s %d. [%s] %s\n", color, i+1, f.ID, f.Title)
+		for _, e := range f.Evidence {
+			fmt.Printf("%s      - %s\n", colorMap["grey"], e)
+		}
+		fmt.Printf("%s      → %s\n", colorMap["reset"], f.Remediation)
+	}
+}
+
+// domainReachable reports whether the domain check for domain got an answer
+func domainReachable(r *Report, domain string) bool {
+	result := r.RequestResult[domain]
+	return result != "" && !strings.Contains(result, "Error")
+}
+
+func findCaptivePortal(r *Report) *Finding {
+	if r.CaptivePortal == nil || !r.CaptivePortal.Detected {
+		return nil
+	}
+	var evidence []string
+	for _, p := range r.CaptivePortal.Probes {
+		if p.Intercepted {
+			evidence = append(evidence, p.URL+": "+p.Reason)
+		}
+	}
+	return &Finding{
+		ID: "captive-portal", Severity: FindingCritical,
+		Title:       "Log in to the network first",
+		Evidence:    evidence,
+		Remediation: "This network holds traffic behind a login page, as hotels, offices and some mobile operators do. Open any http:// site in a browser, log in, then run the diagnostic again.",
+	}
+}
+
+func findUpdaterResponse(r *Report) *Finding {
+	switch r.UpdaterResponse {
+	case "nohost":
+		return &Finding{
+			ID: "ddns-nohost", Severity: FindingCritical,
+			Title:       "Your order is not applied yet or the updater password is wrong",
+			Evidence:    []string{"updater answered: nohost"},
+			Remediation: "Copy the updater link again from your Shecan dashboard and wait a few minutes after buying a plan.",
+		}
+	case "out of the range":
+		return &Finding{
+			ID: "ddns-out-of-range", Severity: FindingCritical,
+			Title:       "Static IP out of range",
+			Evidence:    []string{"updater answered: out of the range", "public IP: " + r.PublicIP},
+			Remediation: "Your order is registered for a static IP range that does not include your current IP. Add this IP in the Shecan dashboard or switch the order to dynamic IP.",
+		}
+	case "invalid":
+		return &Finding{
+			ID: "ddns-invalid", Severity: FindingCritical,
+			Title:       "The updater link is not valid",
+			Evidence:    []string{"updater answered: invalid"},
+			Remediation: "Copy the updater link from your Shecan dashboard; it must look like https://ddns.shecan.ir/update?password=...",
+		}
+	}
+	return nil
+}
+
+func findDDNSMismatch(r *Report) *Finding {
+	p := r.PublicIPs
+	if p == nil || p.DDNSMatch == nil || *p.DDNSMatch {
+		return nil
+	}
+	return &Finding{
+		ID: "ddns-ip-mismatch", Severity: FindingCritical,
+		Title:       "Shecan sees a different IP than the updater registered",
+		Evidence:    []string{"registered by the updater: " + p.DDNSIP, "seen by Shecan: " + r.PublicIP},
+		Remediation: "Your traffic reaches Shecan from another address, usually because of a VPN, proxy or a second internet connection. Run the updater link from the same network you browse from.",
+	}
+}
+
+func findNoShecanDNS(r *Report) *Finding {
+	if r.Plan == 0 || len(r.ShecanDNS) > 0 {
+		return nil
+	}
+	return &Finding{
+		ID: "shecan-dns-unavailable", Severity: FindingCritical,
+		Title:       "The Shecan DNS server list could not be fetched",
+		Evidence:    []string{"plan: " + r.Plan.String()},
+		Remediation: "Check that shecan.ir opens in a browser. If it does not, your connection to Shecan itself is blocked.",
+	}
+}
+
+func findHostsOverride(r *Report) *Finding {
+	if r.Hosts == nil || len(r.Hosts.Overrides) == 0 {
+		return nil
+	}
+	severity := FindingWarning
+	var evidence []string
+	for _, o := range r.Hosts.Overrides {
+		evidence = append(evidence, fmt.Sprintf("%s line %d: %s", o.File, o.Line, o.Entry))
+		if r.Hosts.BeforeDNS && contains(nslookupDomains, o.Domain) {
+			severity = FindingCritical
+		}
+	}
+	switch {
+	case !r.Hosts.Consulted:
+		evidence = append(evidence, "the hosts file is not used for lookups: hosts: "+strings.Join(r.Hosts.Lookup, " "))
+	case !r.Hosts.BeforeDNS:
+		evidence = append(evidence, "the hosts file is only read when DNS has no answer: hosts: "+strings.Join(r.Hosts.Lookup, " "))
+	}
+	return &Finding{
+		ID: "hosts-override", Severity: severity,
+		Title:       "The hosts file overrides checked domains",
+		Evidence:    evidence,
+		Remediation: "Entries in " + r.Hosts.File + " bypass DNS, so Shecan never answers for these names. Remove or comment out the listed lines.",
+	}
+}
+
+// usesShecanDNS reports whether any OS resolver, or the upstream of a local
+// forwarder the OS asks, is one of the plan's servers
+func usesShecanDNS(r *Report) bool {
+	servers := append([]string{}, r.DNSServers...)
+	for _, server := range r.DNSServers {
+		if ip := net.ParseIP(server); ip != nil && ip.IsLoopback() {
+			servers = append(servers, r.LocalDNS.Upstreams()...)
+			break
+		}
+	}
+	for _, server := range servers {
+		if contains(r.ShecanDNS, server) {
+			return true
+		}
+	}
+	return false
+}
+
+// findDNSLeak explains why fail.shecan.ir resolved, which only happens when queries
+// do not reach Shecan
+func findDNSLeak(r *Report) *Finding {
+	if !domainReachable(r, "fail.shecan.ir") {
+		return nil
+	}
+	evidence := []string{
+		"fail.shecan.ir is reachable: " + r.RequestResult["fail.shecan.ir"],
+		"system DNS servers: " + strings.Join(r.DNSServers, ", "),
+	}
+	if upstreams := r.LocalDNS.Upstreams(); len(upstreams) > 0 {
+		evidence = append(evidence, "local DNS forwarder upstreams: "+strings.Join(upstreams, ", "))
+	}
+	if names := r.LocalDNS.UpstreamNames(); len(names) > 0 {
+		evidence = append(evidence, "local DNS forwarder upstream resolvers: "+strings.Join(names, ", "))
+	}
+	if r.VPN.Detected() {
+		evidence = append(evidence, "likely cause: "+r.VPN.Culprit)
+	}
+
+	if usesShecanDNS(r) {
+		return &Finding{
+			ID: "dns-intercepted", Severity: FindingCritical,
+			Title:       "DNS queries are intercepted before they reach Shecan",
+			Evidence:    evidence,
+			Remediation: "Your system is set to Shecan but something answers in its place: a VPN, an antivirus, or an ISP or router forcing DNS. Turn off VPNs and DNS protection features and test again.",
+		}
+	}
+
+	private := len(r.DNSServers) > 0
+	for _, server := range r.DNSServers {
+		if ip := net.ParseIP(server); ip == nil || !ip.IsPrivate() {
+			private = false
+		}
+	}
+	if private {
+		return &Finding{
+			ID: "dns-router-forced", Severity: FindingCritical,
+			Title:       "Your router forces DNS",
+			Evidence:    evidence,
+			Remediation: "Your system asks the router, which uses its own upstream DNS. Set the Shecan servers (" + strings.Join(r.ShecanDNS, ", ") + ") in the router's WAN/DHCP DNS settings or on this device.",
+		}
+	}
+
+	return &Finding{
+		ID: "dns-not-shecan", Severity: FindingCritical,
+		Title:       "Your system DNS is not Shecan",
+		Evidence:    evidence,
+		Remediation: "Set the DNS servers of your network connection to " + strings.Join(r.ShecanDNS, ", ") + ".",
+	}
+}
+
+func findVPNActive(r *Report) *Finding {
+	if !r.VPN.Detected() {
+		return nil
+	}
+	evidence := []string{r.VPN.Culprit}
+	for _, p := range r.VPN.Processes {
+		evidence = append(evidence, fmt.Sprintf("process %s (pid %d)", p.Process, p.PID))
+	}
+	if r.VPN.DefaultViaTunnel != "" {
+		evidence = append(evidence, "default route via "+r.VPN.DefaultViaTunnel)
+	}
+	for _, l := range r.VPN.LocalProxies {
+		evidence = append(evidence, "listening: "+l.String())
+	}
+	severity := FindingWarning
+	if domainReachable(r, "fail.shecan.ir") {
+		severity = FindingCritical
+	}
+	return &Finding{
+		ID: "vpn-active", Severity: severity,
+		Title:       "A VPN or proxy client is running",
+		Evidence:    evidence,
+		Remediation: "VPN and proxy clients send DNS through their own servers, so Shecan is not used. Turn them off, or exclude Shecan's DNS from the tunnel, and test again.",
+	}
+}
+
+func findDNSRedirect(r *Report) *Finding {
+	if r.LocalDNS == nil || len(r.LocalDNS.Redirects) == 0 {
+		return nil
+	}
+	var evidence []string
+	for _, redirect := range r.LocalDNS.Redirects {
+		evidence = append(evidence, fmt.Sprintf("%s %s %s: %s", redirect.Source, redirect.Table, redirect.Chain, redirect.Rule))
+	}
+	severity := FindingWarning
+	if domainReachable(r, "fail.shecan.ir") {
+		severity = FindingCritical
+	}
+	return &Finding{
+		ID: "dns-firewall-redirect", Severity: severity,
+		Title:       "The firewall redirects DNS traffic",
+		Evidence:    evidence,
+		Remediation: "NAT rules send queries for port 53 to another server whatever DNS is configured. Remove the rules or point their target at Shecan.",
+	}
+}
+
+func findShecanUnreachable(r *Report) *Finding {
+	result, checked := r.RequestResult["check.shecan.ir"]
+	if !checked || domainReachable(r, "check.shecan.ir") {
+		return nil
+	}
+	return &Finding{
+		ID: "shecan-unreachable", Severity: FindingCritical,
+		Title:       "check.shecan.ir is unreachable",
+		Evidence:    []string{"check.shecan.ir: " + result},
+		Remediation: "Make sure the internet connection works and nothing blocks HTTPS to Shecan, then run the diagnostic again.",
+	}
+}
+
+// findClockSkew is critical when TLS checks failed too, as the skew then likely explains them
+func findClockSkew(r *Report) *Finding {
+	if r.Clock == nil || !r.Clock.Exceeded {
+		return nil
+	}
+	f := &Finding{
+		ID: "clock-skew", Severity: FindingWarning,
+		Title:       "Your clock is wrong",
+		Evidence:    []string{fmt.Sprintf("local clock is %+.0f seconds off (local %s, reference %s)", r.Clock.SkewSeconds, r.LocalTime, r.RealTime)},
+		Remediation: "Turn on automatic date and time in your system settings and check the time zone. Certificates can't be verified with a wrong clock.",
+	}
+	for _, ip := range sortedKeys(r.CheckShecanResult) {
+		if problem := tlsProblem(r.CheckShecanResult[ip].TLS); problem != "" {
+			f.Severity = FindingCritical
+			f.Evidence = append(f.Evidence, ip+": TLS "+problem)
+		}
+	}
+	return f
+}
+
+func findTLSInterception(r *Report) *Finding {
+	var evidence []string
+	for _, ip := range sortedKeys(r.CheckShecanResult) {
+		if tls := r.CheckShecanResult[ip].TLS; tls != nil && tls.Intercepted {
+			evidence = append(evidence, ip+": certificate not issued by the expected CA")
+		}
+	}
+	if len(evidence) == 0 {
+		return nil
+	}
+	return &Finding{
+		ID: "tls-intercepted", Severity: FindingCritical,
+		Title:       "HTTPS to Shecan is intercepted",
+		Evidence:    evidence,
+		Remediation: "An antivirus, corporate proxy or middlebox decrypts HTTPS. Disable HTTPS scanning or test from another network.",
+	}
+}
+
+func findIPv6DNSLeak(r *Report) *Finding {
+	var leaking []string
+	for _, server := range r.DNSServers {
+		if ip := net.ParseIP(server); ip != nil && ip.To4() == nil && !contains(r.ShecanDNS, server) {
+			leaking = append(leaking, server)
+		}
+	}
+	if len(leaking) == 0 {
+		return nil
+	}
+	return &Finding{
+		ID: "dns-ipv6-leak", Severity: FindingWarning,
+		Title:       "IPv6 DNS leak",
+		Evidence:    []string{"IPv6 DNS servers not belonging to Shecan: " + strings.Join(leaking, ", ")},
+		Remediation: "Shecan serves IPv4 only. Remove the IPv6 DNS servers or disable IPv6 on this connection so queries cannot bypass Shecan.",
+	}
+}
+
+func findPublicIPDisagreement(r *Report) *Finding {
+	if r.PublicIPs == nil || !r.PublicIPs.Disagreement {
+		return nil
+	}
+	var evidence []string
+	for _, obs := range r.PublicIPs.Observations {
+		if obs.IP != "" {
+			evidence = append(evidence, obs.Source+" ("+obs.Family+"): "+obs.IP)
+		}
+	}
+	return &Finding{
+		ID: "public-ip-disagreement", Severity: FindingWarning,
+		Title:       "Different sites see you coming from different IPs",
+		Evidence:    evidence,
+		Remediation: "Part of your traffic takes another route, typically a VPN with split tunnelling or a load-balanced connection. Shecan only works for traffic leaving from your registered IP.",
+	}
+}
+
+func findOverIPFailures(r *Report) *Finding {
+	var evidence []string
+	for _, ip := range sortedKeys(r.CheckShecanResult) {
+		if result := r.CheckShecanResult[ip]; result.Error != "" {
+			evidence = append(evidence, ip+": "+result.Error)
+		}
+	}
+	if len(evidence) == 0 {
+		return nil
+	}
+	return &Finding{
+		ID: "over-ip-failed", Severity: FindingWarning,
+		Title:       "Some Shecan servers can't be reached over HTTPS",
+		Evidence:    evidence,
+		Remediation: "Sites routed through these servers may not open. If it persists, send this report to Shecan support.",
+	}
+}
+
+func findUnreachableDNSServers(r *Report) *Finding {
+	var evidence []string
+	for _, server := range r.ShecanDNS {
+		reach, ok := r.Reachability[strings.TrimSpace(server)]
+		if ok && (reach.Classification == ReachabilityUnreachable || reach.Classification == ReachabilityServiceBlocked) {
+			evidence = append(evidence, server+": "+reach.Classification)
+		}
+	}
+	if len(evidence) == 0 {
+		return nil
+	}
+	return &Finding{
+		ID: "dns-server-unreachable", Severity: FindingWarning,
+		Title:       "Shecan DNS servers are unreachable",
+		Evidence:    evidence,
+		Remediation: "Your ISP or firewall blocks these servers. Use the other Shecan DNS server or contact your ISP.",
+	}
+}
+
+func findPropagationTimeout(r *Report) *Finding {
+	if r.Propagation == nil || r.Propagation.Status != PropagationTimeout {
+		return nil
+	}
+	return &Finding{
+		ID: "ddns-propagation-timeout", Severity: FindingWarning,
+		Title:       "Your new IP is not active on Shecan yet",
+		Evidence:    []string{fmt.Sprintf("check.shecan.ir still answered %d after %.0fs", r.Propagation.LastCode, r.Propagation.WaitedSeconds)},
+		Remediation: "Wait a few minutes after updating the IP and run the diagnostic again.",
+	}
+}
+
+func findHighLatency(r *Report) *Finding {
+	var evidence []string
+	for _, check := range r.Health {
+		if check.Metric == MetricRTT && check.Severity != SeverityHealthy {
+			evidence = append(evidence, fmt.Sprintf("%s: %.2f ms (%s)", check.Target, check.Value, check.Severity))
+		}
+	}
+	if len(evidence) == 0 {
+		return nil
+	}
+	return &Finding{
+		ID: "high-latency", Severity: FindingWarning,
+		Title:       "High latency to Shecan servers",
+		Evidence:    evidence,
+		Remediation: "Check the traces in the report to see whether the delay starts at your router, your ISP or the international link.",
+	}
+}
+
+func findBlockedServices(r *Report) *Finding {
+	var evidence []string
+	for _, check := range r.ServiceChecks {
+		if check.Outcome == ServiceGeoBlocked || check.Outcome == ServiceFiltered {
+			evidence = append(evidence, check.Name+": "+check.Outcome)
+		}
+	}
+	if len(evidence) == 0 {
+		return nil
+	}
+	return &Finding{
+		ID: "service-blocked", Severity: FindingWarning,
+		Title:       "Some sanctioned services are still blocked",
+		Evidence:    evidence,
+		Remediation: "Make sure these services resolve through Shecan; if they do, report them to Shecan support.",
+	}
+}
+
+func findMTUMismatch(r *Report) *Finding {
+	var evidence []string
+	for _, host := range sortedKeys(r.PathMTU) {
+		if mtu := r.PathMTU[host]; mtu.Mismatch {
+			evidence = append(evidence, fmt.Sprintf("%s: path MTU %d, %s MTU %d", host, mtu.PathMTU, mtu.Interface, mtu.InterfaceMTU))
+		}
+	}
+	if len(evidence) == 0 {
+		return nil
+	}
+	return &Finding{
+		ID: "mtu-mismatch", Severity: FindingWarning,
+		Title:       "The path carries smaller packets than your interface sends",
+		Evidence:    evidence,
+		Remediation: "Lower the MTU of your connection or router to the path MTU; this is common on PPPoE and LTE links.",
+	}
+}
+
+func findICMPBlocked(r *Report) *Finding {
+	var evidence []string
+	for _, host := range sortedKeys(r.Reachability) {
+		if r.Reachability[host].Classification == ReachabilityICMPBlocked {
+			evidence = append(evidence, host)
+		}
+	}
+	if len(evidence) == 0 {
+		return nil
+	}
+	return &Finding{
+		ID: "icmp-blocked", Severity: FindingInfo,
+		Title:       "Ping is blocked but the servers work",
+		Evidence:    evidence,
+		Remediation: "Nothing to do; failed pings to these servers are harmless.",
+	}
+}
+
+func findProxyActive(r *Report) *Finding {
+	if r.Proxy == nil || r.Proxy.Active == "" {
+		return nil
+	}
+	return &Finding{
+		ID: "proxy-active", Severity: FindingInfo,
+		Title:       "Requests go through a proxy",
+		Evidence:    []string{"proxy: " + r.Proxy.Active},
+		Remediation: "Results describe the proxy's network. Run with --no-proxy to test your own connection.",
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import "testing"
+
+func findingIDs(findings []Finding) []string {
+	var ids []string
+	for _, f := range findings {
+		ids = append(ids, f.ID)
+	}
+	return ids
+}
+
+func TestDiagnoseDNSLeak(t *testing.T) {
+	leak := map[string]string{"check.shecan.ir": "Shecan is working", "fail.shecan.ir": "ok"}
+	for name, tc := range map[string]struct {
+		dnsServers []string
+		want       string
+	}{
+		"router":      {[]string{"192.168.1.1"}, "dns-router-forced"},
+		"intercepted": {[]string{"178.22.122.100"}, "dns-intercepted"},
+		"public":      {[]string{"8.8.8.8"}, "dns-not-shecan"},
+	} {
+		r := &Report{Plan: Free, ShecanDNS: []string{"178.22.122.100", "185.51.200.2"}, DNSServers: tc.dnsServers, RequestResult: leak}
+		findings := diagnose(r)
+		if len(findings) != 1 || findings[0].ID != tc.want || findings[0].Severity != FindingCritical {
+			t.Errorf("%s: findings = %v", name, findingIDs(findings))
+		}
+	}
+}
+
+func TestDiagnoseVPNLeak(t *testing.T) {
+	r := &Report{
+		Plan: Free, ShecanDNS: []string{"178.22.122.100"}, DNSServers: []string{"178.22.122.100"},
+		RequestResult: map[string]string{"check.shecan.ir": "Shecan is working", "fail.shecan.ir": "ok"},
+		VPN:           &VPNDetection{Processes: []VPNProcess{{Client: "Xray", Process: "xray", PID: 4242}}, Culprit: "Xray running"},
+	}
+	findings := diagnose(r)
+	if got := findingIDs(findings); len(got) != 2 || got[0] != "dns-intercepted" || got[1] != "vpn-active" {
+		t.Fatalf("findings = %v", got)
+	}
+	if findings[1].Severity != FindingCritical || !contains(findings[0].Evidence, "likely cause: Xray running") {
+		t.Errorf("findings = %+v", findings)
+	}
+
+	// without the leak a running client is only a warning
+	r.RequestResult["fail.shecan.ir"] = "Error: no such host"
+	if findings := diagnose(r); len(findings) != 1 || findings[0].Severity != FindingWarning {
+		t.Errorf("findings = %+v", findings)
+	}
+}
+
+func TestDiagnoseRanksBySeverity(t *testing.T) {
+	r := &Report{
+		Plan:            Pro,
+		ShecanDNS:       []string{"178.22.122.100"},
+		DNSServers:      []string{"178.22.122.100", "2001:4860:4860::8888"},
+		UpdaterResponse: "out of the range",
+		Proxy:           &ProxyInfo{Active: "http://proxy.local:3128"},
+		Reachability: map[string]ServerReachability{
+			"10.0.0.1": {Classification: ReachabilityICMPBlocked},
+		},
+		CheckShecanResult: map[string]CheckShecan{
+			"10.0.0.2": {Error: "Error: connection reset"},
+		},
+	}
+	got := findingIDs(diagnose(r))
+	want := []string{"ddns-out-of-range", "dns-ipv6-leak", "over-ip-failed", "icmp-blocked", "proxy-active"}
+	if len(got) != len(want) {
+		t.Fatalf("findings = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("finding %d = %s, want %s", i, got[i], want[i])
+		}
+	}
+}
+
+func TestDiagnoseHealthyReport(t *testing.T) {
+	r := &Report{
+		Plan:          Free,
+		ShecanDNS:     []string{"178.22.122.100"},
+		DNSServers:    []string{"178.22.122.100"},
+		RequestResult: map[string]string{"check.shecan.ir": "Shecan is working", "fail.shecan.ir": "Error: no such host"},
+	}
+	if findings := diagnose(r); len(findings) != 0 {
+		t.Errorf("findings = %v", findingIDs(findings))
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// IPAnnotation is what the local GeoIP databases know about one address
+type IPAnnotation struct {
+	ASN          uint64 `json:"asn,omitempty"`
+	Organization string `json:"organization,omitempty"`
+	ISP          string `json:"isp,omitempty"`
+	Country      string `json:"country,omitempty"` // ISO 3166-1 alpha-2
+	CountryName  string `json:"country_name,omitempty"`
+}
+
+func (a IPAnnotation) String() string {
+	var parts []string
+	if a.ASN != 0 {
+		parts = append(parts, fmt.Sprintf("AS%d", a.ASN))
+	}
+	for _, s := range []string{a.Organization, a.ISP} {
+		if s != "" && !contains(parts, s) {
+			parts = append(parts, s)
+		}
+	}
+	if a.Country != "" {
+		parts = append(parts, "("+a.Country+")")
+	}
+	return strings.Join(parts, " ")
+}
+
+// merge copies the fields a record of a GeoLite2/GeoIP2 ASN, ISP, Country or City
+// database carries. Fields already set by an earlier database are kept.
+func (a *IPAnnotation) merge(record map[string]interface{}) {
+	if a.ASN == 0 {
+		a.ASN = mmdbUint(record["autonomous_system_number"])
+	}
+	for _, field := range []struct {
+		dst  *string
+		keys []string
+	}{
+		{&a.Organization, []string{"autonomous_system_organization", "organization"}},
+		{&a.ISP, []string{"isp"}},
+	} {
+		for _, key := range field.keys {
+			if s, ok := record[key].(string); ok && *field.dst == "" {
+				*field.dst = s
+			}
+		}
+	}
+
+	for _, key := range []string{"country", "registered_country"} {
+		country, ok := record[key].(map[string]interface{})
+		if !ok || a.Country != "" {
+			continue
+		}
+		a.Country, _ = country["iso_code"].(string)
+		if names, ok := country["names"].(map[string]interface{}); ok {
+			a.CountryName, _ = names["en"].(string)
+		}
+	}
+}
+
+// loadGeoIPDatabases opens every .mmdb file listed in GEOIP_DB. Nothing is looked up
+// over the network; without databases no annotation is made.
+func loadGeoIPDatabases() []*mmdbReader {
+	var dbs []*mmdbReader
+	for _, path := range envList("GEOIP_DB") {
+		db, err := openMMDB(path)
+		if err != nil {
+			fmt.Println(colorMap["yellow"], "[Warning] Can't open GeoIP database:", err)
+			continue
+		}
+		dbs = append(dbs, db)
+	}
+	return dbs
+}
+
+// annotateIPs looks every address up in dbs, skipping ones no database knows
+func annotateIPs(dbs []*mmdbReader, ips []string) map[string]IPAnnotation {
+	if len(dbs) == 0 {
+		return nil
+	}
+	annotations := make(map[string]IPAnnotation)
+	for _, raw := range unique(ips) {
+		ip := net.ParseIP(strings.TrimSpace(raw))
+		if ip == nil {
+			continue
+		}
+		var a IPAnnotation
+		for _, db := range dbs {
+			value, err := db.lookup(ip)
+			if record, ok := value.(map[string]interface{}); err == nil && ok {
+				a.merge(record)
+			}
+		}
+		if a != (IPAnnotation{}) {
+			annotations[ip.String()] = a
+		}
+	}
+	return annotations
+}
+
+// annotateReport annotates the public IPs, the system resolvers and the Shecan IPs of r
+func annotateReport(r *Report, shecanIPs []string) {
+	dbs := loadGeoIPDatabases()
+	if len(dbs) == 0 {
+		return
+	}
+	ips := append([]string{}, r.DNSServers...)
+	if r.PublicIPs != nil {
+		ips = append(ips, r.PublicIPs.IPv4, r.PublicIPs.IPv6)
+	} else {
+		ips = append(ips, r.PublicIP)
+	}
+	r.IPAnnotations = annotateIPs(dbs, append(ips, shecanIPs...))
+
+	if a, ok := r.IPAnnotations[r.PublicIP]; ok {
+		fmt.Println(colorMap["blue"], "[INFO] Your network:", a)
+	}
+}
@@ -1,11 +1,16 @@
-module main
+// The module needs a real path: with "module main" the go tool cannot build the
+// test binary of package main ("cannot import main"), so go test fails to compile.
+module github.com/shecanir/diagnostic-app
 
 go 1.23.4
 
+require (
+	github.com/joho/godotenv v1.5.1
+	github.com/spf13/cobra v1.9.1
+)
+
 require (
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
-	github.com/spf13/cobra v1.9.1 // indirect
 	github.com/spf13/pflag v1.0.6 // indirect
 	golang.org/x/sys v0.0.0-20220520151302-bc2c85ada10a // indirect
 )
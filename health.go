@@ -0,0 +1,409 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Health severities, from best to worst
+const (
+	SeverityHealthy  = "healthy"
+	SeverityDegraded = "degraded"
+	SeverityFailed   = "failed"
+)
+
+// Target classes health rules are configured for
+const (
+	TargetDefault  = "default"
+	TargetDNS      = "dns"       // plan DNS servers
+	TargetShecanIP = "shecan_ip" // addresses from ip-list.php
+	TargetService  = "service"   // sanctioned services
+)
+
+// Metrics a health rule can judge
+const (
+	MetricRTT        = "rtt_ms"
+	MetricLoss       = "loss_pct"
+	MetricDNSLatency = "dns_latency_ms"
+	MetricHTTPStatus = "http_status"
+	MetricTLSError   = "tls_error"
+	MetricTCPConnect = "tcp_connect_ms"
+	MetricPathMTU    = "path_mtu"
+	MetricTraceRTT   = "trace_rtt_ms"
+	MetricTraceLoss  = "trace_loss_pct"
+)
+
+//go:embed rules/health.json
+var defaultHealthRules []byte
+
+// Threshold marks a value degraded or failed once it reaches the given level; zero disables a level
+type Threshold struct {
+	Degraded float64 `json:"degraded,omitempty"`
+	Failed   float64 `json:"failed,omitempty"`
+}
+
+func (t *Threshold) severity(v float64) string {
+	switch {
+	case t == nil:
+		return SeverityHealthy
+	case t.Failed > 0 && v >= t.Failed:
+		return SeverityFailed
+	case t.Degraded > 0 && v >= t.Degraded:
+		return SeverityDegraded
+	}
+	return SeverityHealthy
+}
+
+// StatusRule lists the healthy and degraded HTTP status codes, every other status failed
+type StatusRule struct {
+	Healthy  []int `json:"healthy,omitempty"`
+	Degraded []int `json:"degraded,omitempty"`
+}
+
+func (s *StatusRule) severity(code int) string {
+	switch {
+	case s == nil || containsInt(s.Healthy, code):
+		return SeverityHealthy
+	case containsInt(s.Degraded, code):
+		return SeverityDegraded
+	}
+	return SeverityFailed
+}
+
+// HealthRule holds the thresholds for one target class. Unset fields come from the default class.
+type HealthRule struct {
+	RTTMs        *Threshold  `json:"rtt_ms,omitempty"`
+	LossPct      *Threshold  `json:"loss_pct,omitempty"`
+	DNSLatencyMs *Threshold  `json:"dns_latency_ms,omitempty"`
+	TCPConnectMs *Threshold  `json:"tcp_connect_ms,omitempty"`
+	HTTPStatus   *StatusRule `json:"http_status,omitempty"`
+	TLSError     string      `json:"tls_error,omitempty"`  // severity of a failed or intercepted TLS handshake
+	TCPClosed    string      `json:"tcp_closed,omitempty"` // severity of a closed HTTPS or DNS over TLS port
+	PathMTU      string      `json:"path_mtu,omitempty"`   // severity of a path MTU below the interface MTU
+}
+
+// merge returns r with every field set in o replaced
+func (r HealthRule) merge(o HealthRule) HealthRule {
+	if o.RTTMs != nil {
+		r.RTTMs = o.RTTMs
+	}
+	if o.LossPct != nil {
+		r.LossPct = o.LossPct
+	}
+	if o.DNSLatencyMs != nil {
+		r.DNSLatencyMs = o.DNSLatencyMs
+	}
+	if o.TCPConnectMs != nil {
+		r.TCPConnectMs = o.TCPConnectMs
+	}
+	if o.HTTPStatus != nil {
+		r.HTTPStatus = o.HTTPStatus
+	}
+	if o.TLSError != "" {
+		r.TLSError = o.TLSError
+	}
+	if o.TCPClosed != "" {
+		r.TCPClosed = o.TCPClosed
+	}
+	if o.PathMTU != "" {
+		r.PathMTU = o.PathMTU
+	}
+	return r
+}
+
+// HealthRules is the content of the health rules file
+type HealthRules struct {
+	Version int                   `json:"version"`
+	Classes map[string]HealthRule `json:"classes"`
+}
+
+// rule returns the rule of class, completed from the default class
+func (r *HealthRules) rule(class string) HealthRule {
+	rule := r.Classes[TargetDefault].merge(r.Classes[class])
+	if rule.TLSError == "" {
+		rule.TLSError = SeverityFailed
+	}
+	if rule.TCPClosed == "" {
+		rule.TCPClosed = SeverityDegraded
+	}
+	if rule.PathMTU == "" {
+		rule.PathMTU = SeverityDegraded
+	}
+	return rule
+}
+
+// overlay returns the built-in rules with every class and field set in custom replaced
+func (r *HealthRules) overlay(custom *HealthRules) *HealthRules {
+	merged := &HealthRules{Version: r.Version, Classes: map[string]HealthRule{}}
+	if custom.Version != 0 {
+		merged.Version = custom.Version
+	}
+	for class, rule := range r.Classes {
+		merged.Classes[class] = rule
+	}
+	for class, rule := range custom.Classes {
+		merged.Classes[class] = merged.Classes[class].merge(rule)
+	}
+	return merged
+}
+
+var (
+	healthRulesOnce sync.Once
+	healthRules     *HealthRules
+)
+
+// loadHealthRules reads the embedded rules and lays HEALTH_RULES over them if set
+func loadHealthRules() *HealthRules {
+	healthRulesOnce.Do(func() {
+		rules := &HealthRules{}
+		_ = json.Unmarshal(defaultHealthRules, rules)
+
+		if path := os.Getenv("HEALTH_RULES"); path != "" {
+			data, err := os.ReadFile(path)
+			if err == nil {
+				custom := &HealthRules{}
+				if err = json.Unmarshal(data, custom); err == nil {
+					rules = rules.overlay(custom)
+				}
+			}
+			if err != nil {
+				fmt.Println(colorMap["yellow"], "[Warning] Can't load health rules, using built-in rules:", err)
+			}
+		}
+		healthRules = rules
+	})
+	return healthRules
+}
+
+var (
+	targetClassMu sync.Mutex
+	targetClasses = map[string]string{}
+)
+
+// setTargetClass records which health rules apply to hosts
+func setTargetClass(hosts []string, class string) {
+	targetClassMu.Lock()
+	defer targetClassMu.Unlock()
+	for _, host := range hosts {
+		if host = strings.TrimSpace(host); host != "" {
+			targetClasses[host] = class
+		}
+	}
+}
+
+func targetClass(host string) string {
+	targetClassMu.Lock()
+	defer targetClassMu.Unlock()
+	if class, ok := targetClasses[strings.TrimSpace(host)]; ok {
+		return class
+	}
+	return TargetDefault
+}
+
+func resetTargetClasses() {
+	targetClassMu.Lock()
+	targetClasses = map[string]string{}
+	targetClassMu.Unlock()
+}
+
+// worseSeverity returns the more severe of a and b
+func worseSeverity(a, b string) string {
+	rank := map[string]int{SeverityHealthy: 0, SeverityDegraded: 1, SeverityFailed: 2}
+	if rank[b] > rank[a] {
+		return b
+	}
+	return a
+}
+
+// HealthCheck is one probe result judged against the rules of its target class
+type HealthCheck struct {
+	Target   string  `json:"target"`
+	Class    string  `json:"class"`
+	Metric   string  `json:"metric"`
+	Value    float64 `json:"value"`
+	Detail   string  `json:"detail,omitempty"`
+	Severity string  `json:"severity"`
+}
+
+// pingSeverity judges a ping by RTT and loss. A ping without replies has failed, unless
+// the port probes reached the host and only ICMP is blocked.
+func pingSeverity(host string, stats PingStats) string {
+	rule := loadHealthRules().rule(targetClass(host))
+	if stats.Received == 0 {
+		if serviceReachable(host) {
+			return SeverityHealthy
+		}
+		return SeverityFailed
+	}
+	return worseSeverity(rule.RTTMs.severity(stats.avg()), rule.LossPct.severity(stats.LossPct))
+}
+
+// evaluateHealth judges every ping, port probe, path MTU, trace, over-IP check and service check in r
+func evaluateHealth(r *Report) []HealthCheck {
+	rules := loadHealthRules()
+	var checks []HealthCheck
+	add := func(target, class, metric string, value float64, detail, severity string) {
+		checks = append(checks, HealthCheck{Target: target, Class: class, Metric: metric, Value: value, Detail: detail, Severity: severity})
+	}
+
+	for _, host := range sortedKeys(r.PingStats) {
+		stats := r.PingStats[host]
+		class := targetClass(host)
+		rule := rules.rule(class)
+		if stats.Received == 0 {
+			if r.Reachability[host].serviceOpen() {
+				// the same situation findICMPBlocked reports as harmless
+				add(host, class, MetricLoss, 100, "icmp blocked", SeverityHealthy)
+			} else {
+				add(host, class, MetricLoss, 100, "no reply", SeverityFailed)
+			}
+			continue
+		}
+		add(host, class, MetricRTT, stats.avg(), "", rule.RTTMs.severity(stats.avg()))
+		add(host, class, MetricLoss, stats.LossPct, "", rule.LossPct.severity(stats.LossPct))
+	}
+
+	for _, host := range sortedKeys(r.Reachability) {
+		class := targetClass(host)
+		rule := rules.rule(class)
+		for _, probe := range r.Reachability[host].Probes {
+			if probe.Proto == "tcp" && (probe.Port == 443 || probe.Port == 853) {
+				target := net.JoinHostPort(host, strconv.Itoa(probe.Port))
+				if !probe.Open {
+					add(target, class, MetricTCPConnect, 0, probe.Error, rule.TCPClosed)
+				} else {
+					add(target, class, MetricTCPConnect, probe.LatencyMs, "", rule.TCPConnectMs.severity(probe.LatencyMs))
+				}
+				continue
+			}
+			if probe.Proto != "udp" || probe.Port != 53 {
+				continue
+			}
+			if !probe.Open {
+				// only a DNS server has to answer queries
+				if class == TargetDNS {
+					add(host, class, MetricDNSLatency, 0, probe.Error, SeverityFailed)
+				}
+				continue
+			}
+			add(host, class, MetricDNSLatency, probe.LatencyMs, "", rule.DNSLatencyMs.severity(probe.LatencyMs))
+		}
+	}
+
+	for _, host := range sortedKeys(r.PathMTU) {
+		result := r.PathMTU[host]
+		if !result.Mismatch {
+			continue
+		}
+		class := targetClass(host)
+		detail := fmt.Sprintf("%s carries %d", result.Interface, result.InterfaceMTU)
+		add(host, class, MetricPathMTU, float64(result.PathMTU), detail, rules.rule(class).PathMTU)
+	}
+
+	for _, trace := range r.Traces {
+		class := targetClass(trace.Target)
+		rule := rules.rule(class)
+		target := trace.Target + " (" + trace.Proto + ")"
+		if !trace.Reached || len(trace.Hops) == 0 {
+			add(target, class, MetricTraceLoss, 100, "target not reached", rule.LossPct.severity(100))
+			continue
+		}
+		last := trace.Hops[len(trace.Hops)-1]
+		add(target, class, MetricTraceRTT, last.avg(), "", rule.RTTMs.severity(last.avg()))
+		add(target, class, MetricTraceLoss, last.LossPct, "", rule.LossPct.severity(last.LossPct))
+	}
+
+	for _, host := range sortedKeys(r.CheckShecanResult) {
+		result := r.CheckShecanResult[host]
+		class := targetClass(host)
+		rule := rules.rule(class)
+		if result.Code == 0 {
+			add(host, class, MetricHTTPStatus, 0, result.Error, SeverityFailed)
+		} else {
+			add(host, class, MetricHTTPStatus, float64(result.Code), "", rule.HTTPStatus.severity(result.Code))
+		}
+		if detail := tlsProblem(result.TLS); detail != "" {
+			add(host, class, MetricTLSError, 1, detail, rule.TLSError)
+		}
+	}
+
+	rule := rules.rule(TargetService)
+	for _, check := range r.ServiceChecks {
+		if check.Code == 0 {
+			add(check.Name, TargetService, MetricHTTPStatus, 0, check.Classification.Category, SeverityFailed)
+			continue
+		}
+		if check.Outcome != ServiceUnblocked {
+			// a filter or sanctions page can answer with a healthy status
+			add(check.Name, TargetService, MetricHTTPStatus, float64(check.Code), check.Outcome, SeverityFailed)
+			continue
+		}
+		add(check.Name, TargetService, MetricHTTPStatus, float64(check.Code), "", rule.HTTPStatus.severity(check.Code))
+	}
+	return checks
+}
+
+// tlsProblem describes what is wrong with a TLS inspection, or returns "" when nothing is
+func tlsProblem(tls *TLSInspection) string {
+	switch {
+	case tls == nil:
+		return ""
+	case tls.Intercepted:
+		return "intercepted"
+	case tls.Expired:
+		return "expired certificate"
+	case tls.NameMismatch:
+		return "name mismatch"
+	case tls.Error != "":
+		return tls.Error
+	case !tls.Verified:
+		return "not verified"
+	}
+	return ""
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// severityColor is the CLI colour and mark of a severity
+func severityColor(severity string) string {
+	switch severity {
+	case SeverityFailed:
+		return colorMap["red"] + "❌ "
+	case SeverityDegraded:
+		return colorMap["yellow"] + "⚠️  "
+	}
+	return colorMap["grey"] + "✅ "
+}
+
+// printHealthSummary lists every check that is not healthy
+func printHealthSummary(checks []HealthCheck) {
+	unhealthy := 0
+	for _, check := range checks {
+		if check.Severity == SeverityHealthy {
+			continue
+		}
+		unhealthy++
+		line := fmt.Sprintf("%s%s %s %s = %g", severityColor(check.Severity), check.Severity, check.Target, check.Metric, check.Value)
+		if check.Detail != "" {
+			line += " (" + check.Detail + ")"
+		}
+		fmt.Println(line)
+	}
+	if unhealthy == 0 {
+		fmt.Println(severityColor(SeverityHealthy) + "All health checks passed")
+	}
+}
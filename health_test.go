@@ -0,0 +1,132 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestHealthRuleInheritsDefault(t *testing.T) {
+	rules := loadHealthRules()
+	dns := rules.rule(TargetDNS)
+	if dns.RTTMs.severity(200) != SeverityDegraded || rules.rule(TargetDefault).RTTMs.severity(200) != SeverityHealthy {
+		t.Errorf("dns rtt thresholds not applied: %+v", dns.RTTMs)
+	}
+	if dns.LossPct == nil || dns.LossPct.severity(60) != SeverityFailed || dns.TLSError != SeverityFailed {
+		t.Errorf("dns rule did not inherit from default: %+v", dns)
+	}
+	service := rules.rule(TargetService)
+	for code, want := range map[int]string{401: SeverityHealthy, 429: SeverityDegraded, 451: SeverityFailed} {
+		if got := service.HTTPStatus.severity(code); got != want {
+			t.Errorf("service status %d = %s, want %s", code, got, want)
+		}
+	}
+}
+
+func TestEvaluateHealth(t *testing.T) {
+	resetTargetClasses()
+	setTargetClass([]string{"178.22.122.100"}, TargetDNS)
+	setTargetClass([]string{"10.0.0.1", "10.0.0.2"}, TargetShecanIP)
+
+	r := &Report{
+		PingStats: map[string]PingStats{
+			"178.22.122.100": newPingStats(PingResult{Sent: 4, Received: 3, RTTs: []float64{180, 190, 200}}),
+			"10.0.0.2":       newPingStats(PingResult{Sent: 2}),
+			"185.51.200.2":   newPingStats(PingResult{Sent: 4}),
+		},
+		Reachability: map[string]ServerReachability{
+			"178.22.122.100": {Probes: []PortProbe{
+				{Proto: "tcp", Port: 53, Open: true, LatencyMs: 2000},
+				{Proto: "tcp", Port: 443, Open: true, LatencyMs: 400},
+				{Proto: "tcp", Port: 853, Error: "connection refused"},
+				{Proto: "udp", Port: 53, Open: true, LatencyMs: 40},
+			}},
+			// ping is blocked, DNS answers
+			"185.51.200.2": {Classification: ReachabilityICMPBlocked, Probes: []PortProbe{
+				{Proto: "tcp", Port: 53, Open: true, LatencyMs: 30},
+			}},
+		},
+		PathMTU: map[string]PathMTU{
+			"178.22.122.100": {Host: "178.22.122.100", PathMTU: 1400, Interface: "wlan0", InterfaceMTU: 1500, Mismatch: true},
+			"10.0.0.1":       {Host: "10.0.0.1", PathMTU: 1500, Interface: "wlan0", InterfaceMTU: 1500},
+		},
+		Traces: []TraceResult{
+			{Target: "178.22.122.100", Proto: "udp", Reached: true, Hops: []TraceHop{
+				{TTL: 1, PingStats: newPingStats(PingResult{Sent: 3, Received: 3, RTTs: []float64{1, 1, 1}})},
+				{TTL: 2, PingStats: newPingStats(PingResult{Sent: 3, Received: 3, RTTs: []float64{700, 700, 700}})},
+			}},
+			{Target: "10.0.0.1", Proto: "tcp", Hops: []TraceHop{{TTL: 1, PingStats: newPingStats(PingResult{Sent: 3})}}},
+		},
+		CheckShecanResult: map[string]CheckShecan{
+			"10.0.0.1": {Code: 200, TLS: &TLSInspection{Intercepted: true}},
+		},
+		ServiceChecks: []ServiceCheck{
+			{Name: "Docker Hub", Code: 401, Outcome: ServiceUnblocked},
+			{Name: "Spotify", Code: 200, Outcome: ServiceFiltered},
+		},
+	}
+	got := map[string]string{}
+	for _, check := range evaluateHealth(r) {
+		got[check.Target+" "+check.Metric] = check.Severity
+	}
+	want := map[string]string{
+		"178.22.122.100 rtt_ms":               SeverityDegraded,
+		"178.22.122.100 loss_pct":             SeverityDegraded,
+		"178.22.122.100 dns_latency_ms":       SeverityHealthy,
+		"10.0.0.2 loss_pct":                   SeverityFailed,
+		"185.51.200.2 loss_pct":               SeverityHealthy,
+		"10.0.0.1 http_status":                SeverityHealthy,
+		"10.0.0.1 tls_error":                  SeverityFailed,
+		"178.22.122.100:443 tcp_connect_ms":   SeverityDegraded,
+		"178.22.122.100:853 tcp_connect_ms":   SeverityDegraded,
+		"178.22.122.100 path_mtu":             SeverityDegraded,
+		"178.22.122.100 (udp) trace_rtt_ms":   SeverityFailed,
+		"178.22.122.100 (udp) trace_loss_pct": SeverityHealthy,
+		"10.0.0.1 (tcp) trace_loss_pct":       SeverityFailed,
+		"Docker Hub http_status":              SeverityHealthy,
+		"Spotify http_status":                 SeverityFailed,
+	}
+	for key, severity := range want {
+		if got[key] != severity {
+			t.Errorf("%s = %q, want %q", key, got[key], severity)
+		}
+	}
+	// the coloured ping line agrees with the health summary
+	report = Report{Reachability: r.Reachability}
+	t.Cleanup(func() { report = Report{} })
+	if got := pingSeverity("185.51.200.2", r.PingStats["185.51.200.2"]); got == SeverityFailed {
+		t.Errorf("ping to an ICMP blocked server = %s", got)
+	}
+	if got := pingSeverity("10.0.0.2", r.PingStats["10.0.0.2"]); got != SeverityFailed {
+		t.Errorf("ping to an unreachable server = %s", got)
+	}
+	for _, key := range []string{"178.22.122.100:53 tcp_connect_ms", "10.0.0.1 path_mtu"} {
+		if _, ok := got[key]; ok {
+			t.Errorf("%s should not be rated", key)
+		}
+	}
+}
+
+func TestCustomHealthRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "health.json")
+	if err := os.WriteFile(path, []byte(`{"version":3,"classes":{"dns":{"rtt_ms":{"failed":50}},"service":{"tcp_closed":"failed"}}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HEALTH_RULES", path)
+	healthRulesOnce = sync.Once{}
+	t.Cleanup(func() { healthRulesOnce = sync.Once{} })
+
+	rules := loadHealthRules()
+	if rules.Version != 3 || rules.rule(TargetDNS).RTTMs.severity(60) != SeverityFailed || rules.rule(TargetService).TCPClosed != SeverityFailed {
+		t.Errorf("custom rules not loaded: %+v", rules)
+	}
+	// a file only changing a few fields keeps the built-in rules for everything else
+	dns := rules.rule(TargetDNS)
+	if dns.LossPct.severity(100) != SeverityFailed || dns.DNSLatencyMs.severity(200) != SeverityDegraded {
+		t.Errorf("built-in rules dropped: %+v", dns)
+	}
+	if rules.rule(TargetService).HTTPStatus.severity(401) != SeverityHealthy || rules.rule(TargetDefault).RTTMs.severity(400) != SeverityDegraded {
+		t.Error("built-in classes dropped")
+	}
+}
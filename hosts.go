@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+var (
+	// files read by the hosts inspection, variables so tests can point them at fixtures
+	hostsFilePath = defaultHostsFile()
+	nsswitchPath  = "/etc/nsswitch.conf"
+)
+
+func defaultHostsFile() string {
+	if runtime.GOOS == "windows" {
+		root := os.Getenv("SystemRoot")
+		if root == "" {
+			root = `C:\Windows`
+		}
+		return filepath.Join(root, "System32", "drivers", "etc", "hosts")
+	}
+	return "/etc/hosts"
+}
+
+// HostsOverride is a hosts file entry for a checked domain
+type HostsOverride struct {
+	Domain  string `json:"domain"`
+	Address string `json:"address"`
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Entry   string `json:"entry"`
+}
+
+// HostsInspection records how the hosts file takes part in name resolution and
+// which checked domains it overrides
+type HostsInspection struct {
+	File      string          `json:"file"`
+	Lookup    []string        `json:"lookup,omitempty"` // the nsswitch.conf hosts sources, in order
+	Consulted bool            `json:"consulted"`
+	BeforeDNS bool            `json:"before_dns"` // the hosts file wins over DNS
+	Overrides []HostsOverride `json:"overrides,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// parseNSSwitchHosts returns the sources of the hosts database, without [STATUS=action] items
+func parseNSSwitchHosts(content string) []string {
+	for _, line := range strings.Split(content, "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		db, sources, ok := strings.Cut(line, ":")
+		if !ok || strings.TrimSpace(db) != "hosts" {
+			continue
+		}
+		var list []string
+		inAction := false
+		for _, field := range strings.Fields(sources) {
+			switch {
+			case strings.HasPrefix(field, "["):
+				inAction = !strings.HasSuffix(field, "]")
+			case inAction:
+				inAction = !strings.HasSuffix(field, "]")
+			default:
+				list = append(list, field)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// hostsOrder reports whether the hosts file is read at all and whether before DNS.
+// systemd-resolved ("resolve") reads the hosts file itself before asking DNS.
+func hostsOrder(sources []string) (consulted, beforeDNS bool) {
+	if sources == nil {
+		// no nsswitch.conf, as on macOS, Windows and musl: the hosts file comes first
+		return true, true
+	}
+	for _, source := range sources {
+		switch source {
+		case "files", "resolve":
+			return true, true
+		case "dns":
+			consulted = contains(sources, "files")
+			return consulted, false
+		}
+	}
+	return false, false
+}
+
+// parseHostsFile returns the entries of a hosts file naming one of domains
+func parseHostsFile(content, file string, domains []string) []HostsOverride {
+	wanted := map[string]bool{}
+	for _, d := range domains {
+		wanted[strings.ToLower(strings.TrimSuffix(d, "."))] = true
+	}
+
+	var overrides []HostsOverride
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for line := 1; scanner.Scan(); line++ {
+		entry, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(entry)
+		if len(fields) < 2 || net.ParseIP(strings.SplitN(fields[0], "%", 2)[0]) == nil {
+			continue
+		}
+		for _, name := range fields[1:] {
+			name = strings.ToLower(strings.TrimSuffix(name, "."))
+			if wanted[name] {
+				overrides = append(overrides, HostsOverride{
+					Domain: name, Address: fields[0], File: file, Line: line, Entry: strings.TrimSpace(entry),
+				})
+			}
+		}
+	}
+	return overrides
+}
+
+// checkedDomains are the Shecan domains and the hosts of the service targets
+func checkedDomains(services []ServiceTarget) []string {
+	domains := append([]string{}, nslookupDomains...)
+	for _, service := range services {
+		if u, err := url.Parse(service.URL); err == nil && u.Hostname() != "" && !contains(domains, u.Hostname()) {
+			domains = append(domains, u.Hostname())
+		}
+	}
+	return domains
+}
+
+// inspectHosts looks for hosts file entries that take domains away from DNS
+func inspectHosts(domains []string) *HostsInspection {
+	inspection := &HostsInspection{File: hostsFilePath}
+	if data, err := os.ReadFile(nsswitchPath); err == nil {
+		inspection.Lookup = parseNSSwitchHosts(string(data))
+		if inspection.Lookup == nil {
+			// glibc defaults to "files dns" when the hosts line is missing
+			inspection.Lookup = []string{"files", "dns"}
+		}
+	}
+	inspection.Consulted, inspection.BeforeDNS = hostsOrder(inspection.Lookup)
+
+	data, err := os.ReadFile(hostsFilePath)
+	if err != nil {
+		inspection.Error = err.Error()
+		return inspection
+	}
+	inspection.Overrides = parseHostsFile(string(data), hostsFilePath, domains)
+	return inspection
+}
+
+func printHostsInspection(inspection *HostsInspection) {
+	for _, o := range inspection.Overrides {
+		color, level := colorMap["red"], "[Error]"
+		if !inspection.BeforeDNS {
+			color, level = colorMap["yellow"], "[Warning]"
+		}
+		fmt.Printf("%s %s %s is set to %s in %s line %d\n", color, level, o.Domain, o.Address, o.File, o.Line)
+	}
+}
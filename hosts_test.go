@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const recordedHosts = `127.0.0.1	localhost
+::1	localhost ip6-localhost
+# 10.10.34.35	check.shecan.ir
+10.10.34.35	Check.Shecan.IR.   # stale entry
+192.0.2.80	api.openai.com www.example.com
+not-an-ip	shecan.ir
+`
+
+func TestParseHostsFile(t *testing.T) {
+	want := []HostsOverride{
+		{Domain: "check.shecan.ir", Address: "10.10.34.35", File: "hosts", Line: 4, Entry: "10.10.34.35	Check.Shecan.IR."},
+		{Domain: "api.openai.com", Address: "192.0.2.80", File: "hosts", Line: 5, Entry: "192.0.2.80	api.openai.com www.example.com"},
+	}
+	domains := checkedDomains([]ServiceTarget{{Name: "OpenAI", URL: "https://api.openai.com/v1/models"}})
+	if got := parseHostsFile(recordedHosts, "hosts", domains); !reflect.DeepEqual(got, want) {
+		t.Errorf("overrides = %+v", got)
+	}
+}
+
+func TestHostsOrder(t *testing.T) {
+	for name, tc := range map[string]struct {
+		nsswitch             string
+		consulted, beforeDNS bool
+	}{
+		"debian":    {"passwd: files\nhosts:  files mdns4_minimal [NOTFOUND=return] dns myhostname\n", true, true},
+		"resolved":  {"hosts: mymachines resolve [!UNAVAIL=return] files myhostname dns\n", true, true},
+		"dns first": {"hosts: dns [ NOTFOUND=return ] files\n", true, false},
+		"no files":  {"hosts: dns\n", false, false},
+	} {
+		sources := parseNSSwitchHosts(tc.nsswitch)
+		if consulted, before := hostsOrder(sources); consulted != tc.consulted || before != tc.beforeDNS {
+			t.Errorf("%s: sources %v, consulted %v, before DNS %v", name, sources, consulted, before)
+		}
+	}
+	if got := parseNSSwitchHosts("hosts: dns [ NOTFOUND=return ] files"); !reflect.DeepEqual(got, []string{"dns", "files"}) {
+		t.Errorf("sources = %v", got)
+	}
+}
+
+func TestInspectHosts(t *testing.T) {
+	dir := t.TempDir()
+	hostsFilePath, nsswitchPath = filepath.Join(dir, "hosts"), filepath.Join(dir, "nsswitch.conf")
+	t.Cleanup(func() { hostsFilePath, nsswitchPath = defaultHostsFile(), "/etc/nsswitch.conf" })
+	if err := os.WriteFile(hostsFilePath, []byte(recordedHosts), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tc := range map[string]struct {
+		nsswitch  string
+		beforeDNS bool
+		severity  string
+	}{
+		"files first": {"hosts: files dns\n", true, FindingCritical},
+		"dns first":   {"hosts: dns files\n", false, FindingWarning},
+		"no files":    {"hosts: dns\n", false, FindingWarning},
+	} {
+		if err := os.WriteFile(nsswitchPath, []byte(tc.nsswitch), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		inspection := inspectHosts(nslookupDomains)
+		if inspection.BeforeDNS != tc.beforeDNS || len(inspection.Overrides) != 1 {
+			t.Fatalf("%s: inspection = %+v", name, inspection)
+		}
+		r := &Report{Hosts: inspection}
+		if findings := diagnose(r); len(findings) != 1 || findings[0].ID != "hosts-override" || findings[0].Severity != tc.severity {
+			t.Errorf("%s: findings = %+v", name, findings)
+		}
+	}
+}
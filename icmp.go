@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Ping methods, in the order they are tried
+const (
+	PingMethodDatagram = "icmp-dgram" // unprivileged ICMP socket, allowed by ping_group_range
+	PingMethodRaw      = "icmp-raw"   // raw socket, needs root or CAP_NET_RAW
+	PingMethodBinary   = "binary"     // the system ping command
+)
+
+var (
+	// errNoICMPSocket means neither an unprivileged nor a raw ICMP socket could be opened
+	errNoICMPSocket = errors.New("no ICMP socket available")
+	// errNativeUnsupported means the target needs the ping binary, e.g. an IPv6 address
+	errNativeUnsupported = errors.New("target not supported by the native ping engine")
+)
+
+// PingResult holds the per-packet outcome of pinging one host
+type PingResult struct {
+	Method   string    `json:"method"`
+	Address  string    `json:"address,omitempty"`
+	Sent     int       `json:"sent"`
+	Received int       `json:"received"`
+	RTTs     []float64 `json:"rtts_ms,omitempty"`
+	LostSeqs []int     `json:"lost_seqs,omitempty"`
+	TTL      int       `json:"ttl,omitempty"`
+	Error    string    `json:"error,omitempty"`
+
+	// set instead of RTTs when only the summary line of the ping binary was understood
+	SummaryOnly bool    `json:"summary_only,omitempty"`
+	SummaryAvg  float64 `json:"summary_avg_ms,omitempty"`
+}
+
+const (
+	icmpEchoRequest = 8
+	icmpEchoReply   = 0
+	icmpPayloadSize = 56
+	pingInterval    = time.Second
+)
+
+var icmpIDCounter uint32
+
+// nextICMPID returns an identifier unique to this ping run, so concurrent raw sockets
+// can tell their replies apart
+func nextICMPID() uint16 {
+	return uint16(os.Getpid()) ^ uint16(atomic.AddUint32(&icmpIDCounter, 1)*7919)
+}
+
+func icmpChecksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// buildEchoRequest encodes an ICMPv4 echo request carrying the send time in its payload
+func buildEchoRequest(id, seq uint16, sent time.Time) []byte {
+	return buildSizedEchoRequest(id, seq, sent, icmpPayloadSize)
+}
+
+// buildSizedEchoRequest is buildEchoRequest with a payload of size bytes, at least 8
+func buildSizedEchoRequest(id, seq uint16, sent time.Time, size int) []byte {
+	packet := make([]byte, 8+size)
+	packet[0] = icmpEchoRequest
+	binary.BigEndian.PutUint16(packet[4:], id)
+	binary.BigEndian.PutUint16(packet[6:], seq)
+	binary.BigEndian.PutUint64(packet[8:], uint64(sent.UnixNano()))
+	binary.BigEndian.PutUint16(packet[2:], icmpChecksum(packet))
+	return packet
+}
+
+// parseEchoReply decodes an ICMPv4 echo reply. A leading IPv4 header, which macOS
+// includes on datagram sockets, is stripped and its TTL returned.
+func parseEchoReply(b []byte) (id, seq uint16, ttl int, ok bool) {
+	if len(b) >= 20 && b[0]>>4 == 4 {
+		headerLen := int(b[0]&0x0f) * 4
+		if len(b) < headerLen {
+			return 0, 0, 0, false
+		}
+		ttl = int(b[8])
+		b = b[headerLen:]
+	}
+	if len(b) < 8 || b[0] != icmpEchoReply {
+		return 0, 0, 0, false
+	}
+	return binary.BigEndian.Uint16(b[4:]), binary.BigEndian.Uint16(b[6:]), ttl, true
+}
+
+// icmpPing pings server with count echo requests using the best ICMP socket available
+func icmpPing(server string, count int, timeout time.Duration) (PingResult, error) {
+	target, err := resolveIPv4(server, timeout)
+	if err != nil {
+		return PingResult{}, err
+	}
+
+	sock, err := openICMPSocket()
+	if err != nil {
+		return PingResult{}, err
+	}
+	defer sock.Close()
+
+	result := PingResult{Method: sock.method, Address: target.String()}
+	id := nextICMPID()
+	buf := make([]byte, 1500)
+
+	for seq := 0; seq < count; seq++ {
+		sent := time.Now()
+		if _, err := sock.send(buildEchoRequest(id, uint16(seq), sent), target); err != nil {
+			result.Error = err.Error()
+			break
+		}
+		result.Sent++
+
+		deadline := sent.Add(timeout)
+		got := false
+		for !got {
+			sock.conn.SetReadDeadline(deadline)
+			n, from, ttl, err := sock.readReply(buf)
+			if err != nil {
+				break
+			}
+			if !sameIP(from, target) {
+				continue
+			}
+			replyID, replySeq, headerTTL, ok := parseEchoReply(buf[:n])
+			// datagram sockets rewrite the identifier, the kernel already demultiplexed for us
+			if !ok || int(replySeq) != seq || (sock.method == PingMethodRaw && replyID != id) {
+				continue
+			}
+			if headerTTL > 0 {
+				ttl = headerTTL
+			}
+			result.RTTs = append(result.RTTs, float64(time.Since(sent).Microseconds())/1000)
+			result.Received++
+			if ttl > 0 {
+				result.TTL = ttl
+			}
+			got = true
+		}
+		if !got {
+			result.LostSeqs = append(result.LostSeqs, seq)
+		}
+
+		if wait := pingInterval - time.Since(sent); seq < count-1 && wait > 0 {
+			time.Sleep(wait)
+		}
+	}
+
+	return result, nil
+}
+
+// icmpSocket is an ICMPv4 socket opened by openICMPSocket
+type icmpSocket struct {
+	conn   net.PacketConn
+	method string
+}
+
+func (s *icmpSocket) Close() error {
+	return s.conn.Close()
+}
+
+func (s *icmpSocket) send(b []byte, ip net.IP) (int, error) {
+	if s.method == PingMethodDatagram {
+		return s.conn.WriteTo(b, &net.UDPAddr{IP: ip})
+	}
+	return s.conn.WriteTo(b, &net.IPAddr{IP: ip})
+}
+
+// readReply reads one packet and the TTL from its control message when the platform provides it
+func (s *icmpSocket) readReply(buf []byte) (int, net.Addr, int, error) {
+	oob := make([]byte, 128)
+	switch c := s.conn.(type) {
+	case *net.UDPConn:
+		n, oobn, _, from, err := c.ReadMsgUDP(buf, oob)
+		if err != nil {
+			return 0, nil, 0, err
+		}
+		return n, from, ttlFromControl(oob[:oobn]), nil
+	case *net.IPConn:
+		n, oobn, _, from, err := c.ReadMsgIP(buf, oob)
+		if err != nil {
+			return 0, nil, 0, err
+		}
+		return n, from, ttlFromControl(oob[:oobn]), nil
+	}
+	n, from, err := s.conn.ReadFrom(buf)
+	return n, from, 0, err
+}
+
+func sameIP(addr net.Addr, ip net.IP) bool {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP.Equal(ip)
+	case *net.IPAddr:
+		return a.IP.Equal(ip)
+	}
+	return false
+}
+
+func resolveIPv4(server string, timeout time.Duration) (net.IP, error) {
+	if ip := net.ParseIP(strings.TrimSpace(server)); ip != nil {
+		if ip.To4() == nil {
+			return nil, fmt.Errorf("%w: %s is not IPv4", errNativeUnsupported, server)
+		}
+		return ip.To4(), nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	addrs, err := lookupIPAddr(ctx, server)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if ip := addr.IP.To4(); ip != nil {
+			return ip, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: no IPv4 address for %s", errNativeUnsupported, server)
+}
@@ -0,0 +1,17 @@
+//go:build !linux && !darwin
+
+package main
+
+import "net"
+
+// openICMPSocket only has raw sockets to offer here, which need administrator rights
+func openICMPSocket() (*icmpSocket, error) {
+	if conn, err := net.ListenPacket("ip4:icmp", "0.0.0.0"); err == nil {
+		return &icmpSocket{conn: conn, method: PingMethodRaw}, nil
+	}
+	return nil, errNoICMPSocket
+}
+
+func ttlFromControl(oob []byte) int {
+	return 0
+}
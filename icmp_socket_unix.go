@@ -0,0 +1,67 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// openICMPSocket prefers an unprivileged datagram socket and falls back to a raw one
+func openICMPSocket() (*icmpSocket, error) {
+	if conn, err := openDatagramICMP(); err == nil {
+		return &icmpSocket{conn: conn, method: PingMethodDatagram}, nil
+	}
+	if conn, err := net.ListenPacket("ip4:icmp", "0.0.0.0"); err == nil {
+		if raw, ok := conn.(*net.IPConn); ok {
+			if rc, err := raw.SyscallConn(); err == nil {
+				rc.Control(func(fd uintptr) {
+					syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_RECVTTL, 1)
+				})
+			}
+		}
+		return &icmpSocket{conn: conn, method: PingMethodRaw}, nil
+	}
+	return nil, errNoICMPSocket
+}
+
+// openDatagramICMP opens a SOCK_DGRAM/IPPROTO_ICMP socket, which Linux only allows when
+// our group is inside net.ipv4.ping_group_range
+func openDatagramICMP() (net.PacketConn, error) {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, syscall.IPPROTO_ICMP)
+	if err != nil {
+		return nil, err
+	}
+	syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, syscall.IP_RECVTTL, 1)
+	if err := syscall.Bind(fd, &syscall.SockaddrInet4{}); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	f := os.NewFile(uintptr(fd), "icmp")
+	defer f.Close()
+	return net.FilePacketConn(f)
+}
+
+// ttlFromControl extracts the TTL from an IP_TTL/IP_RECVTTL control message
+func ttlFromControl(oob []byte) int {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0
+	}
+	for _, m := range msgs {
+		if m.Header.Level != syscall.IPPROTO_IP || (m.Header.Type != syscall.IP_TTL && m.Header.Type != syscall.IP_RECVTTL) {
+			continue
+		}
+		// Linux sends a native-endian int, macOS a single byte; a TTL fits in one byte either way
+		ttl := 0
+		for _, b := range m.Data {
+			if int(b) > ttl {
+				ttl = int(b)
+			}
+		}
+		return ttl
+	}
+	return 0
+}
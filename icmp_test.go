@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEchoRequestRoundTrip(t *testing.T) {
+	packet := buildEchoRequest(0x1234, 7, time.Now())
+	if icmpChecksum(packet) != 0 {
+		t.Fatal("checksum of an encoded packet should verify to zero")
+	}
+
+	// turn the request into the reply a peer would send back, behind an IPv4 header
+	reply := append([]byte(nil), packet...)
+	reply[0] = icmpEchoReply
+	header := make([]byte, 20)
+	header[0], header[8] = 0x45, 57
+	id, seq, ttl, ok := parseEchoReply(append(header, reply...))
+	if !ok || id != 0x1234 || seq != 7 || ttl != 57 {
+		t.Errorf("parseEchoReply = %x %d %d %v", id, seq, ttl, ok)
+	}
+
+	if _, _, _, ok := parseEchoReply(packet); ok {
+		t.Error("an echo request must not be taken for a reply")
+	}
+}
+
+func TestICMPPingLoopback(t *testing.T) {
+	result, err := icmpPing("127.0.0.1", 2, time.Second)
+	if errors.Is(err, errNoICMPSocket) {
+		t.Skip("no ICMP socket available in this environment")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Sent != 2 || result.Received != 2 || len(result.LostSeqs) != 0 || newPingStats(result).avg() <= 0 {
+		t.Errorf("result = %+v", result)
+	}
+	t.Logf("method=%s ttl=%d rtts=%v", result.Method, result.TTL, result.RTTs)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// Steering outcomes for a sanctioned domain
+const (
+	SteeringShecan     = "shecan_proxy" // every answer is a Shecan proxy
+	SteeringPartial    = "partial"      // some answers are Shecan proxies, some are not
+	SteeringOrigin     = "origin"       // no answer is a Shecan proxy, Shecan is bypassed
+	SteeringUnresolved = "unresolved"
+	SteeringUnknown    = "unknown" // every answer is in an address family the list does not cover
+)
+
+// IPList matches addresses against the entries of ip-list.php, which may be plain IPs or CIDRs
+type IPList struct {
+	ips        map[string]struct{}
+	nets       []*net.IPNet
+	ipv4, ipv6 bool // address families the entries cover
+}
+
+func newIPList(entries []string) *IPList {
+	list := &IPList{ips: map[string]struct{}{}}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			list.nets = append(list.nets, network)
+			list.addFamily(network.IP)
+			continue
+		}
+		if ip := net.ParseIP(entry); ip != nil {
+			list.ips[ip.String()] = struct{}{}
+			list.addFamily(ip)
+		}
+	}
+	return list
+}
+
+func (l *IPList) addFamily(ip net.IP) {
+	if ip.To4() != nil {
+		l.ipv4 = true
+	} else {
+		l.ipv6 = true
+	}
+}
+
+// Covers reports whether the list has entries in the address family of addr
+func (l *IPList) Covers(addr string) bool {
+	ip := net.ParseIP(strings.TrimSpace(addr))
+	if ip == nil {
+		return false
+	}
+	if ip.To4() != nil {
+		return l.ipv4
+	}
+	return l.ipv6
+}
+
+// Contains reports whether addr is one of the listed IPs or inside a listed network
+func (l *IPList) Contains(addr string) bool {
+	ip := net.ParseIP(strings.TrimSpace(addr))
+	if ip == nil {
+		return false
+	}
+	if _, ok := l.ips[ip.String()]; ok {
+		return true
+	}
+	for _, network := range l.nets {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// steeringFor classifies resolved addresses against the Shecan proxy list. Only answers in
+// a family the list covers count, so AAAA answers are not taken for origin IPs when the
+// list is IPv4 only.
+func steeringFor(resolved []string, list *IPList) (string, []string) {
+	if len(resolved) == 0 {
+		return SteeringUnresolved, nil
+	}
+	var compared int
+	var matched []string
+	for _, addr := range resolved {
+		if !list.Covers(addr) {
+			continue
+		}
+		compared++
+		if list.Contains(addr) {
+			matched = append(matched, addr)
+		}
+	}
+	switch len(matched) {
+	case compared:
+		if compared == 0 {
+			return SteeringUnknown, nil
+		}
+		return SteeringShecan, matched
+	case 0:
+		return SteeringOrigin, matched
+	default:
+		return SteeringPartial, matched
+	}
+}
+
+// annotateSteering records whether each service resolved to a Shecan proxy
+func annotateSteering(checks []ServiceCheck, ipList []string) {
+	list := newIPList(ipList)
+	for i := range checks {
+		check := &checks[i]
+		check.Steering, check.ShecanIPs = steeringFor(check.ResolvedIPs, list)
+		switch check.Steering {
+		case SteeringOrigin:
+			fmt.Println(colorMap["red"], "[Error]", check.Domain, "resolved to origin IP", strings.Join(check.ResolvedIPs, ", "), "- Shecan is being bypassed")
+		case SteeringPartial:
+			fmt.Println(colorMap["yellow"], "[Warning]", check.Domain, "resolved partly outside Shecan proxies:", strings.Join(check.ResolvedIPs, ", "))
+		case SteeringShecan:
+			fmt.Println(colorMap["green"], "[Success]", check.Domain, "is steered to Shecan proxies")
+		case SteeringUnknown:
+			fmt.Println(colorMap["yellow"], "[Warning]", check.Domain, "resolved only to addresses the Shecan IP list does not cover:", strings.Join(check.ResolvedIPs, ", "))
+		}
+	}
+}
+
+// fetchShecanIPs downloads ip-list.php and returns its non-empty lines
+func fetchShecanIPs() ([]string, error) {
+	response, err := HTTPRequest(endpoints.Check + "/ip-list.php")
+	if err != nil {
+		return nil, errors.New("Can't Get Shecan IPs")
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, errors.New("Can't Read Shecan IPs")
+	}
+
+	var ips []string
+	for _, ip := range strings.Split(string(body), "\n") {
+		if ip != "" {
+			ips = append(ips, ip)
+		}
+	}
+	return ips, nil
+}
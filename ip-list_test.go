@@ -0,0 +1,56 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewIPList(t *testing.T) {
+	list := newIPList([]string{"185.51.200.1", " 10.202.10.0/24 ", "", "not an ip"})
+	for addr, want := range map[string]bool{
+		"185.51.200.1":  true,
+		"10.202.10.10":  true,
+		"10.202.11.10":  false,
+		"185.51.200.2":  false,
+		"2001:db8::1":   false,
+		"not an ip":     false,
+		" 185.51.200.1": true,
+	} {
+		if got := list.Contains(addr); got != want {
+			t.Errorf("Contains(%q) = %v", addr, got)
+		}
+	}
+	if !list.Covers("1.2.3.4") || list.Covers("2001:db8::1") {
+		t.Errorf("families: %+v", list)
+	}
+
+	dual := newIPList([]string{"185.51.200.1", "2a0b:4140::/32"})
+	if !dual.Contains("2a0b:4140::10") || !dual.Covers("2001:db8::1") {
+		t.Errorf("ipv6 entries: %+v", dual)
+	}
+}
+
+func TestSteeringFor(t *testing.T) {
+	ipv4 := newIPList([]string{"185.51.200.1", "185.51.200.2"})
+	dual := newIPList([]string{"185.51.200.1", "2a0b:4140::/32"})
+	for name, tc := range map[string]struct {
+		resolved []string
+		list     *IPList
+		steering string
+		matched  []string
+	}{
+		"unresolved":          {nil, ipv4, SteeringUnresolved, nil},
+		"shecan":              {[]string{"185.51.200.1", "185.51.200.2"}, ipv4, SteeringShecan, []string{"185.51.200.1", "185.51.200.2"}},
+		"origin":              {[]string{"142.250.185.78"}, ipv4, SteeringOrigin, nil},
+		"partial":             {[]string{"185.51.200.1", "142.250.185.78"}, ipv4, SteeringPartial, []string{"185.51.200.1"}},
+		"aaaa outside list":   {[]string{"185.51.200.1", "2a00:1450:4001::200e"}, ipv4, SteeringShecan, []string{"185.51.200.1"}},
+		"only aaaa":           {[]string{"2a00:1450:4001::200e"}, ipv4, SteeringUnknown, nil},
+		"aaaa in a dual list": {[]string{"185.51.200.1", "2a00:1450:4001::200e"}, dual, SteeringPartial, []string{"185.51.200.1"}},
+		"shecan in both":      {[]string{"185.51.200.1", "2a0b:4140::10"}, dual, SteeringShecan, []string{"185.51.200.1", "2a0b:4140::10"}},
+	} {
+		steering, matched := steeringFor(tc.resolved, tc.list)
+		if steering != tc.steering || !reflect.DeepEqual(matched, tc.matched) {
+			t.Errorf("%s: steeringFor = %s, %v", name, steering, matched)
+		}
+	}
+}
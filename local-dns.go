@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// configRoot prefixes the resolver configuration paths, a variable so tests can use fixtures
+var configRoot = "/"
+
+// LocalResolver is a DNS server listening on port 53 of this machine
+type LocalResolver struct {
+	Software      string   `json:"software"`
+	Process       string   `json:"process,omitempty"`
+	PID           int      `json:"pid,omitempty"`
+	Listen        []string `json:"listen"`
+	Upstreams     []string `json:"upstreams,omitempty"`
+	UpstreamNames []string `json:"upstream_names,omitempty"` // resolver names, e.g. the server_names of dnscrypt-proxy
+	ConfigFile    string   `json:"config_file,omitempty"`
+}
+
+// FirewallRedirect is a NAT rule that sends DNS traffic somewhere else
+type FirewallRedirect struct {
+	Source string `json:"source"` // nft or iptables
+	Table  string `json:"table"`
+	Chain  string `json:"chain"`
+	Rule   string `json:"rule"`
+	Target string `json:"target,omitempty"`
+}
+
+// LocalDNS records local DNS forwarders and firewall redirects of port 53, which hide the real upstream
+type LocalDNS struct {
+	Resolvers []LocalResolver    `json:"resolvers,omitempty"`
+	Redirects []FirewallRedirect `json:"redirects,omitempty"`
+	Errors    []string           `json:"errors,omitempty"`
+}
+
+// Upstreams returns the upstream server addresses of every local resolver
+func (l *LocalDNS) Upstreams() []string {
+	var upstreams []string
+	if l == nil {
+		return nil
+	}
+	for _, resolver := range l.Resolvers {
+		upstreams = append(upstreams, resolver.Upstreams...)
+	}
+	return unique(upstreams)
+}
+
+// UpstreamNames returns the upstream resolver names of every local resolver
+func (l *LocalDNS) UpstreamNames() []string {
+	var names []string
+	if l == nil {
+		return nil
+	}
+	for _, resolver := range l.Resolvers {
+		names = append(names, resolver.UpstreamNames...)
+	}
+	return unique(names)
+}
+
+// resolverSoftware names a DNS server from its process name, or from the systemd-resolved stub address
+func resolverSoftware(process, address string) string {
+	lower := strings.ToLower(process)
+	for _, name := range []string{"dnsmasq", "unbound", "systemd-resolve", "named", "dnscrypt-proxy", "stubby", "adguardhome", "pihole-ftl", "coredns", "knot-resolver", "kresd"} {
+		if strings.HasPrefix(lower, name) {
+			if name == "systemd-resolve" {
+				return "systemd-resolved"
+			}
+			return name
+		}
+	}
+	// without root the owner of the socket is unknown, but the stub addresses are not
+	if address == "127.0.0.53" || address == "127.0.0.54" {
+		return "systemd-resolved"
+	}
+	return "unknown"
+}
+
+// findLocalResolvers groups the :53 listeners by owner
+func findLocalResolvers(listeners []LocalListener) []LocalResolver {
+	var resolvers []LocalResolver
+	index := map[string]int{}
+	for _, l := range listeners {
+		if l.Port != 53 {
+			continue
+		}
+		software := resolverSoftware(l.Process, l.Address)
+		key := fmt.Sprintf("%s/%d", software, l.PID)
+		i, ok := index[key]
+		if !ok {
+			i = len(resolvers)
+			index[key] = i
+			resolvers = append(resolvers, LocalResolver{Software: software, Process: l.Process, PID: l.PID})
+		}
+		listen := l.Proto + " " + net.JoinHostPort(l.Address, "53")
+		if !contains(resolvers[i].Listen, listen) {
+			resolvers[i].Listen = append(resolvers[i].Listen, listen)
+		}
+	}
+	return resolvers
+}
+
+var (
+	dnsmasqServerPattern  = regexp.MustCompile(`^server=(?:/[^/]*/)?([^/#\s]+)`)
+	unboundForwardPattern = regexp.MustCompile(`^forward-addr:\s*(\S+)`)
+	dnscryptServerPattern = regexp.MustCompile(`^server_names\s*=\s*\[(.*)\]`)
+)
+
+// readConfigLines returns the trimmed, non-comment lines of the files matching the patterns under configRoot
+func readConfigLines(patterns ...string) ([]string, string) {
+	var lines []string
+	first := ""
+	for _, pattern := range patterns {
+		files, _ := filepath.Glob(filepath.Join(configRoot, pattern))
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				continue
+			}
+			if first == "" {
+				first = file
+			}
+			scanner := bufio.NewScanner(strings.NewReader(string(data)))
+			for scanner.Scan() {
+				line := strings.TrimSpace(scanner.Text())
+				if line != "" && !strings.HasPrefix(line, "#") {
+					lines = append(lines, line)
+				}
+			}
+		}
+	}
+	return lines, first
+}
+
+// unboundForwardAddr strips the port and TLS name from an unbound forward-addr, as in
+// 178.22.122.100@53#dns.example
+func unboundForwardAddr(value string) string {
+	value, _, _ = strings.Cut(value, "#")
+	value, _, _ = strings.Cut(value, "@")
+	return value
+}
+
+// resolverUpstreams reads the upstream server addresses, or the resolver names where the
+// software selects upstreams by name, from the configuration of known resolvers
+func resolverUpstreams(software string) (upstreams, names []string, file string) {
+	switch software {
+	case "systemd-resolved":
+		// the upstreams resolved learned from DHCP and its own configuration
+		lines, file := readConfigLines("run/systemd/resolve/resolv.conf")
+		return parseResolvConf(strings.Join(lines, "\n")), nil, file
+	case "dnsmasq":
+		lines, file := readConfigLines("etc/dnsmasq.conf", "etc/dnsmasq.d/*")
+		resolvFile := "etc/resolv.conf"
+		noResolv := false
+		for _, line := range lines {
+			if m := dnsmasqServerPattern.FindStringSubmatch(line); m != nil {
+				upstreams = append(upstreams, m[1])
+			}
+			if path, ok := strings.CutPrefix(line, "resolv-file="); ok {
+				resolvFile = strings.TrimPrefix(path, "/")
+			}
+			noResolv = noResolv || line == "no-resolv"
+		}
+		if !noResolv {
+			resolv, _ := readConfigLines(resolvFile)
+			for _, server := range parseResolvConf(strings.Join(resolv, "\n")) {
+				// dnsmasq skips itself in resolv.conf
+				if ip := net.ParseIP(server); ip != nil && !ip.IsLoopback() {
+					upstreams = append(upstreams, server)
+				}
+			}
+		}
+		return unique(upstreams), nil, file
+	case "unbound":
+		lines, file := readConfigLines("etc/unbound/unbound.conf", "etc/unbound/unbound.conf.d/*.conf")
+		for _, line := range lines {
+			if m := unboundForwardPattern.FindStringSubmatch(line); m != nil {
+				upstreams = append(upstreams, unboundForwardAddr(m[1]))
+			}
+		}
+		return unique(upstreams), nil, file
+	case "dnscrypt-proxy":
+		lines, file := readConfigLines("etc/dnscrypt-proxy/dnscrypt-proxy.toml")
+		for _, line := range lines {
+			if m := dnscryptServerPattern.FindStringSubmatch(line); m != nil {
+				for _, name := range strings.Split(m[1], ",") {
+					if name = strings.Trim(strings.TrimSpace(name), `"'`); name != "" {
+						names = append(names, name)
+					}
+				}
+			}
+		}
+		return nil, names, file
+	}
+	return nil, nil, ""
+}
+
+var (
+	nftTablePattern     = regexp.MustCompile(`^table\s+(\S+)\s+(\S+)`)
+	nftChainPattern     = regexp.MustCompile(`^chain\s+(\S+)`)
+	nftTargetPattern    = regexp.MustCompile(`\b(?:dnat|redirect)\b(?:\s+(?:ip6?))?\s+to\s+(\S+)`)
+	iptablesChain       = regexp.MustCompile(`^-A\s+(\S+)`)
+	iptablesTarget      = regexp.MustCompile(`--to-(?:destination|ports)\s+(\S+)`)
+	dnsPortMatchPattern = regexp.MustCompile(`dport\s+(?:\{[^}]*\b53\b[^}]*\}|53\b)|--dports?\s+(?:\S*,)?53\b`)
+)
+
+// parseNftRuleset returns the dnat and redirect rules of port 53 in "nft list ruleset" output
+func parseNftRuleset(output string) []FirewallRedirect {
+	var redirects []FirewallRedirect
+	table, chain := "", ""
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if m := nftTablePattern.FindStringSubmatch(line); m != nil {
+			table = m[1] + " " + m[2]
+			continue
+		}
+		if m := nftChainPattern.FindStringSubmatch(line); m != nil {
+			chain = m[1]
+			continue
+		}
+		if !dnsPortMatchPattern.MatchString(line) {
+			continue
+		}
+		if m := nftTargetPattern.FindStringSubmatch(line); m != nil {
+			redirects = append(redirects, FirewallRedirect{Source: "nft", Table: table, Chain: chain, Rule: line, Target: m[1]})
+		}
+	}
+	return redirects
+}
+
+// parseIptablesSave returns the DNAT and REDIRECT rules of port 53 in iptables-save output
+func parseIptablesSave(output string) []FirewallRedirect {
+	var redirects []FirewallRedirect
+	table := ""
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "*") {
+			table = line[1:]
+			continue
+		}
+		m := iptablesChain.FindStringSubmatch(line)
+		if m == nil || !dnsPortMatchPattern.MatchString(line) ||
+			!(strings.Contains(line, "-j DNAT") || strings.Contains(line, "-j REDIRECT")) {
+			continue
+		}
+		redirect := FirewallRedirect{Source: "iptables", Table: table, Chain: m[1], Rule: line}
+		if target := iptablesTarget.FindStringSubmatch(line); target != nil {
+			redirect.Target = target[1]
+		}
+		redirects = append(redirects, redirect)
+	}
+	return redirects
+}
+
+// firewallRedirects reads the nftables and iptables rulesets. Both need root.
+func firewallRedirects() ([]FirewallRedirect, []string) {
+	var redirects []FirewallRedirect
+	var errs []string
+	if result, err := runCommand(10*time.Second, "nft", "list", "ruleset"); err == nil {
+		redirects = append(redirects, parseNftRuleset(result.Stdout)...)
+	} else {
+		errs = append(errs, "nft: "+err.Error())
+	}
+	if result, err := runCommand(10*time.Second, "iptables-save", "-t", "nat"); err == nil {
+		redirects = append(redirects, parseIptablesSave(result.Stdout)...)
+	} else {
+		errs = append(errs, "iptables-save: "+err.Error())
+	}
+	return redirects, errs
+}
+
+// detectLocalDNS finds the local DNS forwarders with their upstreams and the DNS redirects of the firewall
+func detectLocalDNS(listeners []LocalListener) *LocalDNS {
+	local := &LocalDNS{Resolvers: findLocalResolvers(listeners)}
+	for i := range local.Resolvers {
+		resolver := &local.Resolvers[i]
+		resolver.Upstreams, resolver.UpstreamNames, resolver.ConfigFile = resolverUpstreams(resolver.Software)
+	}
+	if runtime.GOOS == "linux" {
+		local.Redirects, local.Errors = firewallRedirects()
+	}
+	return local
+}
+
+func printLocalDNS(local *LocalDNS) {
+	for _, resolver := range local.Resolvers {
+		upstreams := strings.Join(append(append([]string{}, resolver.Upstreams...), resolver.UpstreamNames...), ", ")
+		if upstreams == "" {
+			upstreams = "unknown"
+		}
+		fmt.Printf("%s [INFO] Local DNS server %s on %s, upstream %s\n", colorMap["blue"], resolver.Software, strings.Join(resolver.Listen, ", "), upstreams)
+	}
+	for _, redirect := range local.Redirects {
+		fmt.Printf("%s [Warning] Firewall redirects DNS (%s %s %s): %s\n", colorMap["yellow"], redirect.Source, redirect.Table, redirect.Chain, redirect.Rule)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const recordedNftRuleset = `table ip nat {
+	chain prerouting {
+		type nat hook prerouting priority dstnat; policy accept;
+		iifname "br-lan" udp dport 53 dnat to 192.168.1.1:53
+		tcp dport { 53, 853 } redirect to :5353
+		tcp dport 443 dnat to 10.0.0.5
+	}
+}
+table inet filter {
+	chain input {
+		udp dport 53 accept
+	}
+}
+`
+
+const recordedIptablesSave = `# Generated by iptables-save v1.8.7
+*nat
+:PREROUTING ACCEPT [0:0]
+-A PREROUTING -i wlan0 -p udp -m udp --dport 53 -j DNAT --to-destination 10.8.0.1:53
+-A OUTPUT -p tcp -m multiport --dports 80,53 -j REDIRECT --to-ports 5353
+-A POSTROUTING -o eth0 -j MASQUERADE
+COMMIT
+`
+
+func TestParseFirewallRedirects(t *testing.T) {
+	want := []FirewallRedirect{
+		{Source: "nft", Table: "ip nat", Chain: "prerouting", Rule: `iifname "br-lan" udp dport 53 dnat to 192.168.1.1:53`, Target: "192.168.1.1:53"},
+		{Source: "nft", Table: "ip nat", Chain: "prerouting", Rule: "tcp dport { 53, 853 } redirect to :5353", Target: ":5353"},
+	}
+	if got := parseNftRuleset(recordedNftRuleset); !reflect.DeepEqual(got, want) {
+		t.Errorf("nft = %+v", got)
+	}
+	got := parseIptablesSave(recordedIptablesSave)
+	if len(got) != 2 || got[0].Chain != "PREROUTING" || got[0].Target != "10.8.0.1:53" || got[1].Table != "nat" || got[1].Target != "5353" {
+		t.Errorf("iptables = %+v", got)
+	}
+}
+
+func TestDetectLocalResolvers(t *testing.T) {
+	configRoot = t.TempDir()
+	t.Cleanup(func() { configRoot = "/" })
+	write := func(path, content string) {
+		full := filepath.Join(configRoot, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("run/systemd/resolve/resolv.conf", "nameserver 178.22.122.100\nnameserver 185.51.200.2\n")
+	write("etc/dnsmasq.conf", "# local cache\nserver=1.1.1.1\nserver=/corp.example/10.0.0.53\n")
+	write("etc/resolv.conf", "nameserver 127.0.0.1\nnameserver 9.9.9.9\n")
+	write("etc/unbound/unbound.conf.d/forward.conf", "forward-zone:\n  name: \".\"\n  forward-addr: 178.22.122.100@53#dns.shecan.ir\n  forward-addr: 2620:fe::fe@853#dns.quad9.net\n  forward-addr: 8.8.8.8\n")
+	write("etc/dnscrypt-proxy/dnscrypt-proxy.toml", "listen_addresses = ['127.0.2.1:53']\nserver_names = ['cloudflare', \"quad9-dnscrypt-ip4-filter-pri\"]\n")
+
+	listeners := []LocalListener{
+		{Proto: "udp", Address: "127.0.0.53", Port: 53},
+		{Proto: "tcp", Address: "127.0.0.53", Port: 53},
+		{Proto: "udp", Address: "127.0.0.1", Port: 53, Process: "dnsmasq", PID: 700},
+		{Proto: "udp", Address: "::1", Port: 53, Process: "unbound", PID: 800},
+		{Proto: "udp", Address: "127.0.2.1", Port: 53, Process: "dnscrypt-proxy", PID: 900},
+		{Proto: "tcp", Address: "127.0.0.1", Port: 10808, Process: "xray", PID: 812},
+	}
+	local := detectLocalDNS(listeners)
+	if len(local.Resolvers) != 4 {
+		t.Fatalf("resolvers = %+v", local.Resolvers)
+	}
+	for i, want := range []LocalResolver{
+		{Software: "systemd-resolved", Listen: []string{"udp 127.0.0.53:53", "tcp 127.0.0.53:53"}, Upstreams: []string{"178.22.122.100", "185.51.200.2"}},
+		{Software: "dnsmasq", Process: "dnsmasq", PID: 700, Listen: []string{"udp 127.0.0.1:53"}, Upstreams: []string{"1.1.1.1", "10.0.0.53", "9.9.9.9"}},
+		{Software: "unbound", Process: "unbound", PID: 800, Listen: []string{"udp [::1]:53"}, Upstreams: []string{"178.22.122.100", "2620:fe::fe", "8.8.8.8"}},
+		{Software: "dnscrypt-proxy", Process: "dnscrypt-proxy", PID: 900, Listen: []string{"udp 127.0.2.1:53"}, UpstreamNames: []string{"cloudflare", "quad9-dnscrypt-ip4-filter-pri"}},
+	} {
+		got := local.Resolvers[i]
+		got.ConfigFile = ""
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("resolver %d = %+v", i, got)
+		}
+	}
+
+	if upstreams := local.Upstreams(); contains(upstreams, "cloudflare") || !contains(upstreams, "178.22.122.100") {
+		t.Errorf("upstreams = %v", upstreams)
+	}
+
+	// resolved forwarding to Shecan means the system does use Shecan
+	r := &Report{ShecanDNS: []string{"178.22.122.100"}, DNSServers: []string{"127.0.0.53"}, LocalDNS: local}
+	if !usesShecanDNS(r) {
+		t.Error("local forwarder upstreams are not taken into account")
+	}
+}
+
+func TestDiagnoseDNSRedirect(t *testing.T) {
+	r := &Report{LocalDNS: &LocalDNS{Redirects: parseNftRuleset(recordedNftRuleset)}}
+	findings := diagnose(r)
+	if len(findings) != 1 || findings[0].ID != "dns-firewall-redirect" || findings[0].Severity != FindingWarning || len(findings[0].Evidence) != 2 {
+		t.Errorf("findings = %+v", findings)
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// socket states of /proc/net/{tcp,udp}
+const (
+	procTCPListen  = "0A"
+	procUDPUnbound = "07" // a UDP socket waiting for datagrams
+)
+
+// procRoot is where process and socket tables are read on Linux, a variable so tests can use fixtures
+var procRoot = "/proc"
+
+// LocalProcess is a running process
+type LocalProcess struct {
+	PID  int    `json:"pid"`
+	Name string `json:"name"`
+}
+
+// LocalListener is a socket waiting for connections or datagrams on this machine
+type LocalListener struct {
+	Proto   string `json:"proto"` // tcp or udp
+	Address string `json:"address"`
+	Port    int    `json:"port"`
+	Process string `json:"process,omitempty"`
+	PID     int    `json:"pid,omitempty"`
+
+	inode string
+}
+
+func (l LocalListener) String() string {
+	s := l.Proto + " " + net.JoinHostPort(l.Address, strconv.Itoa(l.Port))
+	if l.Process != "" {
+		s += " (" + l.Process + ")"
+	}
+	return s
+}
+
+// decodeProcAddr decodes an address of /proc/net/*, written as 32-bit words in host byte order
+func decodeProcAddr(field string) (string, int, bool) {
+	host, port, ok := strings.Cut(field, ":")
+	if !ok {
+		return "", 0, false
+	}
+	raw, err := hex.DecodeString(host)
+	if err != nil || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return "", 0, false
+	}
+	ip := make(net.IP, len(raw))
+	for i := 0; i < len(raw); i += 4 {
+		binary.BigEndian.PutUint32(ip[i:], binary.LittleEndian.Uint32(raw[i:]))
+	}
+	p, err := strconv.ParseUint(port, 16, 16)
+	if err != nil {
+		return "", 0, false
+	}
+	return ip.String(), int(p), true
+}
+
+// parseProcNetSockets returns the sockets of a /proc/net/{tcp,udp}[6] table in the given state
+func parseProcNetSockets(content, proto, state string) []LocalListener {
+	var listeners []LocalListener
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 10 || fields[3] != state {
+			continue
+		}
+		addr, port, ok := decodeProcAddr(fields[1])
+		if !ok {
+			continue
+		}
+		listeners = append(listeners, LocalListener{Proto: proto, Address: addr, Port: port, inode: fields[9]})
+	}
+	return listeners
+}
+
+var (
+	// netstat -an on macOS: "tcp4  0  0  127.0.0.1.1080  *.*  LISTEN" and "udp4  0  0  *.53  *.*"
+	macNetstatPattern = regexp.MustCompile(`(?m)^(tcp|udp)[46]*\s+\d+\s+\d+\s+(\S+)\.(\d+)\s+\S+\s*(LISTEN)?\s*$`)
+	// netstat -ano on Windows: "TCP  127.0.0.1:1080  0.0.0.0:0  LISTENING  4242" and "UDP  0.0.0.0:53  *:*  4242"
+	windowsNetstatPattern = regexp.MustCompile(`(?m)^\s*(TCP|UDP)\s+(\S+):(\d+)\s+\S+\s+(LISTENING\s+)?(\d+)\s*$`)
+)
+
+// parseNetstat reads the listening TCP and the bound UDP sockets of netstat -an (macOS) or -ano (Windows)
+func parseNetstat(output string) []LocalListener {
+	var listeners []LocalListener
+	for _, m := range macNetstatPattern.FindAllStringSubmatch(output, -1) {
+		if m[1] == "tcp" && m[4] == "" {
+			continue
+		}
+		port, _ := strconv.Atoi(m[3])
+		addr := m[2]
+		if addr == "*" {
+			addr = "0.0.0.0"
+		}
+		listeners = append(listeners, LocalListener{Proto: m[1], Address: strings.SplitN(addr, "%", 2)[0], Port: port})
+	}
+	for _, m := range windowsNetstatPattern.FindAllStringSubmatch(output, -1) {
+		proto := strings.ToLower(m[1])
+		if proto == "tcp" && m[4] == "" {
+			continue
+		}
+		port, _ := strconv.Atoi(m[3])
+		pid, _ := strconv.Atoi(m[5])
+		addr := strings.Trim(m[2], "[]")
+		if addr == "*" {
+			addr = "0.0.0.0"
+		}
+		listeners = append(listeners, LocalListener{Proto: proto, Address: strings.SplitN(addr, "%", 2)[0], Port: port, PID: pid})
+	}
+	return listeners
+}
+
+// listProcesses lists the running processes, from /proc on Linux and ps or tasklist elsewhere
+func listProcesses() []LocalProcess {
+	var processes []LocalProcess
+	switch runtime.GOOS {
+	case "linux":
+		entries, _ := os.ReadDir(procRoot)
+		for _, entry := range entries {
+			pid, err := strconv.Atoi(entry.Name())
+			if err != nil {
+				continue
+			}
+			comm, err := os.ReadFile(filepath.Join(procRoot, entry.Name(), "comm"))
+			if err != nil {
+				continue
+			}
+			processes = append(processes, LocalProcess{PID: pid, Name: strings.TrimSpace(string(comm))})
+		}
+	case "windows":
+		result, err := runCommand(10*time.Second, "tasklist", "/fo", "csv", "/nh")
+		if err != nil {
+			return nil
+		}
+		processes = parseTasklist(result.Stdout)
+	default:
+		result, err := runCommand(10*time.Second, "ps", "-axo", "pid=,comm=")
+		if err != nil {
+			return nil
+		}
+		processes = parsePS(result.Stdout)
+	}
+	sort.Slice(processes, func(i, j int) bool { return processes[i].PID < processes[j].PID })
+	return processes
+}
+
+// parsePS reads "ps -axo pid=,comm=", where comm may be a full path
+func parsePS(output string) []LocalProcess {
+	var processes []LocalProcess
+	for _, line := range strings.Split(output, "\n") {
+		pid, comm, ok := strings.Cut(strings.TrimSpace(line), " ")
+		n, err := strconv.Atoi(pid)
+		if !ok || err != nil {
+			continue
+		}
+		processes = append(processes, LocalProcess{PID: n, Name: filepath.Base(strings.TrimSpace(comm))})
+	}
+	return processes
+}
+
+// parseTasklist reads "tasklist /fo csv /nh": "name","pid","session","#","memory"
+func parseTasklist(output string) []LocalProcess {
+	var processes []LocalProcess
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Split(strings.TrimSpace(line), `","`)
+		if len(fields) < 2 {
+			continue
+		}
+		pid, err := strconv.Atoi(strings.Trim(fields[1], `"`))
+		if err != nil {
+			continue
+		}
+		processes = append(processes, LocalProcess{PID: pid, Name: strings.TrimSuffix(strings.Trim(fields[0], `"`), ".exe")})
+	}
+	return processes
+}
+
+// socketOwners maps socket inodes to the processes holding them. Without root only
+// the user's own processes can be read.
+func socketOwners() map[string]LocalProcess {
+	owners := map[string]LocalProcess{}
+	for _, process := range listProcesses() {
+		dir := filepath.Join(procRoot, strconv.Itoa(process.PID), "fd")
+		fds, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(dir, fd.Name()))
+			if err == nil && strings.HasPrefix(link, "socket:[") {
+				owners[strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")] = process
+			}
+		}
+	}
+	return owners
+}
+
+// localListeners lists the listening TCP and bound UDP sockets with their owners where known
+func localListeners() []LocalListener {
+	var listeners []LocalListener
+	switch runtime.GOOS {
+	case "linux":
+		for _, table := range []struct{ file, proto, state string }{
+			{"tcp", "tcp", procTCPListen}, {"tcp6", "tcp", procTCPListen},
+			{"udp", "udp", procUDPUnbound}, {"udp6", "udp", procUDPUnbound},
+		} {
+			data, err := os.ReadFile(filepath.Join(procRoot, "net", table.file))
+			if err == nil {
+				listeners = append(listeners, parseProcNetSockets(string(data), table.proto, table.state)...)
+			}
+		}
+		owners := socketOwners()
+		for i := range listeners {
+			if owner, ok := owners[listeners[i].inode]; ok {
+				listeners[i].Process, listeners[i].PID = owner.Name, owner.PID
+			}
+		}
+	case "windows":
+		result, err := runCommand(10*time.Second, "netstat", "-ano")
+		if err != nil {
+			return nil
+		}
+		listeners = parseNetstat(result.Stdout)
+		names := map[int]string{}
+		for _, process := range listProcesses() {
+			names[process.PID] = process.Name
+		}
+		for i := range listeners {
+			listeners[i].Process = names[listeners[i].PID]
+		}
+	default:
+		result, err := runCommand(10*time.Second, "netstat", "-an")
+		if err != nil {
+			return nil
+		}
+		listeners = parseNetstat(result.Stdout)
+	}
+	return listeners
+}
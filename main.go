@@ -2,10 +2,12 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
 	"regexp"
 	"strings"
 	"time"
@@ -45,7 +47,13 @@ func parsePlan(input string) Plan {
 }
 
 var (
-	report Report = initReport()
+	report Report
+
+	// stdin is where interactive answers are read from
+	stdin io.Reader = os.Stdin
+
+	// nslookupDomains are looked up and requested; fail.shecan.ir must not resolve through Shecan
+	nslookupDomains = []string{"shecan.ir", "check.shecan.ir", "fail.shecan.ir"}
 )
 
 // MarshalJSON converts the Plan enum to a JSON string
@@ -96,8 +104,52 @@ func runDiagnostic() {
 	// print the logo
 	printLogo()
 
+	endpoints = loadEndpoints()
+	if err := configureProxy(ProxyFlag, NoProxyFlag); err != nil {
+		fmt.Println(colorMap["red"], "[Error]", err)
+		return
+	}
+	resetHTTPReachable()
+	resetTargetClasses()
+	resetCommandLog()
+	setupCookieJar()
+	defer saveCookieJar()
+
+	// a captive portal answers every probe in place of the real network, so look for
+	// one before anything else goes out
+	captivePortal := detectCaptivePortal()
+	report = initReport()
+	report.CaptivePortal = captivePortal
+	if captivePortal.Detected {
+		fmt.Println(colorMap["red"], "[Error] This network shows a login page. Log in to the network first, then run the diagnostic again")
+		if captivePortal.PortalURL != "" {
+			fmt.Println(colorMap["red"], "[Error] Login page:", captivePortal.PortalURL)
+		}
+		return
+	}
+	report.PublicIPs = detectPublicIPs()
+	report.PublicIP = report.PublicIPs.primary()
+	recordClock(&report, checkClock())
+	printNetworkInventory(report.Network)
+	listeners := localListeners()
+	report.VPN = detectVPN(report.Network, listProcesses(), listeners)
+	printVPNDetection(report.VPN)
+	report.LocalDNS = detectLocalDNS(listeners)
+	printLocalDNS(report.LocalDNS)
+	report.Hosts = inspectHosts(checkedDomains(loadServices().Services))
+	printHostsInspection(report.Hosts)
+	report.Proxy = detectProxy()
+	printProxyInfo(report.Proxy)
+
+	// whichever step ends the run early, still explain what the results so far mean
+	defer func() {
+		if report.Findings == nil {
+			printFindings(diagnose(&report))
+		}
+	}()
+
 	var selectedPlan Plan
-	reader := bufio.NewReader(os.Stdin)
+	reader := bufio.NewReader(stdin)
 	if PlanFlag != "" {
 		selectedPlan = parsePlan(PlanFlag)
 	} else {
@@ -105,7 +157,6 @@ func runDiagnostic() {
 		fmt.Printf("%d. %s\n", Free, Free)
 		fmt.Printf("%d. %s\n", Pro, Pro)
 
-		reader := bufio.NewReader(os.Stdin)
 		fmt.Print("Enter your choice (1 or 2, default is Pro): ")
 		input, _ := reader.ReadString('\n')
 		input = strings.TrimSpace(input)
@@ -121,6 +172,11 @@ func runDiagnostic() {
 
 	// get shecan DNS servers based on the selected plan
 	shecanDNS := checkDNS(selectedPlan)
+	for _, server := range shecanDNS {
+		if server = strings.TrimSpace(server); server != "" {
+			report.ShecanDNS = append(report.ShecanDNS, server)
+		}
+	}
 
 	// check os DNS servers if shecan not set return error check with report.DNSServers
 	if len(shecanDNS) == 0 {
@@ -162,6 +218,10 @@ func runDiagnostic() {
 			return
 		}
 		responseBodyStr := string(responseBody)
+		report.UpdaterResponse = responseBodyStr
+		if report.PublicIPs != nil {
+			report.PublicIPs.recordDDNSAddress(responseBodyStr)
+		}
 
 		if responseBodyStr == "nohost" {
 			fmt.Println(colorMap["red"], "[Error] Your Order not applied yet or your password is wrong")
@@ -173,17 +233,23 @@ func runDiagnostic() {
 			fmt.Println(colorMap["red"], "[Error] Your updater link is not valid")
 			return
 		} else {
-			// if check.shecan.ir get 403 wait for 1 minute and check again
-			response, err := HTTPRequest("https://check.shecan.ir", "GET", "", "", "2")
-			if err != nil {
+			// while check.shecan.ir answers 403 the new IP has not propagated yet, keep polling
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+			propagation := waitForPropagation(ctx, endpoints.Check,
+				envDuration("PROPAGATION_POLL_INTERVAL", 10*time.Second),
+				envDuration("PROPAGATION_MAX_WAIT", 2*time.Minute))
+			stop()
+			report.Propagation = &propagation
+			switch propagation.Status {
+			case PropagationError:
 				fmt.Println(colorMap["red"], "[Error] Can't Get Check Shecan Response")
 				return
-			}
-			defer response.Body.Close()
-			if response.StatusCode == 403 {
-				// delay for 1 minute
-				fmt.Println(colorMap["yellow"], "[Warning] Waiting for bit...")
-				time.Sleep(1 * time.Minute)
+			case PropagationTimeout:
+				fmt.Printf("%s [Warning] check.shecan.ir still answers 403 after %.0fs\n", colorMap["yellow"], propagation.WaitedSeconds)
+			case PropagationCancelled:
+				fmt.Println(colorMap["yellow"], "[Warning] Waiting for check.shecan.ir skipped")
+			default:
+				fmt.Printf("%s [INFO] check.shecan.ir ready after %.0fs\n", colorMap["blue"], propagation.WaitedSeconds)
 			}
 		}
 	}
@@ -193,8 +259,6 @@ func runDiagnostic() {
 	if report.NsLookup == nil {
 		report.NsLookup = make(map[string][]DNSRecord)
 	}
-	nslookupDomains := []string{"shecan.ir", "check.shecan.ir", "fail.shecan.ir"}
-
 	for _, domain := range nslookupDomains {
 		report.NsLookup[domain] = NsLookup(domain)
 	}
@@ -204,6 +268,7 @@ func runDiagnostic() {
 		report.RequestResult = make(map[string]string)
 	}
 
+	report.SignatureVersion = loadSignatures().Version
 	fmt.Println(colorMap["blue"], "[INFO] Checking shecan domains...")
 	performShecanDomainChecks(nslookupDomains[1:])
 
@@ -218,32 +283,21 @@ func runDiagnostic() {
 	// if fail.shecan.ir is reachable return error and said you are used other DNS servers, VPN, forced DNS, ...
 
 	if report.RequestResult["fail.shecan.ir"] != "" && !strings.Contains(report.RequestResult["fail.shecan.ir"], "Error") {
-		fmt.Println(colorMap["red"], "[Error] You are used other DNS servers, VPN, forced DNS, ...")
+		if report.VPN.Detected() {
+			fmt.Println(colorMap["red"], "[Error] fail.shecan.ir is reachable, your DNS does not go through Shecan. Likely cause:", report.VPN.Culprit)
+		} else {
+			fmt.Println(colorMap["red"], "[Error] You are used other DNS servers, VPN, forced DNS, ...")
+		}
 		return
 	}
 
 	// get the ips of shecan from https://check.shecan.ir/ip-list.php if not error return error and exit
-	response, err := HTTPRequest("https://check.shecan.ir/ip-list.php")
-	if err != nil {
-		fmt.Println(colorMap["red"], "[Error] Can't Get Shecan IPs")
-		return
-	}
-	defer response.Body.Close()
-	body, err := io.ReadAll(response.Body)
+	IPs, err := fetchShecanIPs()
 	if err != nil {
-		fmt.Println(colorMap["red"], "[Error] Can't Read Shecan IPs")
+		fmt.Println(colorMap["red"], "[Error]", err)
 		return
 	}
-	IPs := strings.Split(string(body), "\n")
-
-	// remove empty strings from IPs
-	var newIPs []string
-	for _, ip := range IPs {
-		if ip != "" {
-			newIPs = append(newIPs, ip)
-		}
-	}
-	IPs = newIPs
+	setTargetClass(IPs, TargetShecanIP)
 
 	if report.CheckShecanResult == nil {
 		report.CheckShecanResult = make(map[string]CheckShecan)
@@ -251,7 +305,33 @@ func runDiagnostic() {
 
 	fmt.Println(colorMap["blue"], "[INFO] Checking shecan Over IPS...")
 	performShecanOverIPChecks(IPs)
+
+	fmt.Println(colorMap["blue"], "[INFO] Checking sanctioned services...")
+	report.ServiceChecks = performServiceChecks(loadServices().Services)
+	annotateSteering(report.ServiceChecks, IPs)
+
+	fmt.Println(colorMap["blue"], "[INFO] Probing shecan ports...")
+	performPortProbes(IPs)
 	runConcurrentPings(IPs, 2, 2)
+	classifyReachability()
+
+	fmt.Println(colorMap["blue"], "[INFO] Probing path MTU...")
+	performPMTUProbes(IPs)
+
+	// one round keeps the run short, the trace command probes more thoroughly
+	if opts := traceOptionsFromEnv(1); opts.Rounds > 0 {
+		fmt.Println(colorMap["blue"], "[INFO] Tracing path to shecan servers, this can take a few minutes; set TRACE_ROUNDS=0 to skip it...")
+		report.Traces = performTraces(append(shecanDNS, IPs...), opts)
+	}
+
+	annotateReport(&report, IPs)
+
+	fmt.Println(colorMap["blue"], "[INFO] Health summary:")
+	report.Health = evaluateHealth(&report)
+	printHealthSummary(report.Health)
+	report.Findings = diagnose(&report)
+	printFindings(report.Findings)
+	report.Commands = commandHistory()
 	fmt.Println(colorMap["green"], "[Success] Report Generated Successfully")
 	_err := sendReport(report)
 	if _err != nil {
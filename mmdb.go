@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+)
+
+// mmdbMetadataMarker precedes the metadata map at the end of every MaxMind DB file
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+var errMMDBCorrupt = errors.New("corrupt MaxMind DB")
+
+// mmdbReader reads MaxMind DB files (https://maxmind.github.io/MaxMind-DB/) held in memory.
+// Only lookups are supported, which is all the diagnostic needs.
+type mmdbReader struct {
+	tree       []byte
+	data       mmdbDecoder
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	ipv4Start  uint
+	dbType     string
+}
+
+func openMMDB(path string) (*mmdbReader, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseMMDB(buf)
+}
+
+func parseMMDB(buf []byte) (*mmdbReader, error) {
+	markerAt := bytes.LastIndex(buf, mmdbMetadataMarker)
+	if markerAt < 0 {
+		return nil, fmt.Errorf("%w: no metadata", errMMDBCorrupt)
+	}
+	raw, _, err := mmdbDecoder(buf[markerAt+len(mmdbMetadataMarker):]).decode(0)
+	if err != nil {
+		return nil, err
+	}
+	meta, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: metadata is not a map", errMMDBCorrupt)
+	}
+
+	r := &mmdbReader{
+		nodeCount:  uint(mmdbUint(meta["node_count"])),
+		recordSize: uint(mmdbUint(meta["record_size"])),
+		ipVersion:  uint(mmdbUint(meta["ip_version"])),
+	}
+	r.dbType, _ = meta["database_type"].(string)
+	if r.recordSize != 24 && r.recordSize != 28 && r.recordSize != 32 {
+		return nil, fmt.Errorf("%w: record size %d", errMMDBCorrupt, r.recordSize)
+	}
+
+	// the search tree is followed by 16 zero bytes, then the data section
+	treeSize := r.recordSize * 2 / 8 * r.nodeCount
+	if treeSize+16 > uint(markerAt) {
+		return nil, fmt.Errorf("%w: search tree larger than the file", errMMDBCorrupt)
+	}
+	r.tree = buf[:treeSize]
+	r.data = mmdbDecoder(buf[treeSize+16 : markerAt])
+
+	// IPv4 addresses live under ::/96 of an IPv6 tree
+	if r.ipVersion == 6 {
+		for i := 0; i < 96 && r.ipv4Start < r.nodeCount; i++ {
+			r.ipv4Start = r.record(r.ipv4Start, 0)
+		}
+	}
+	return r, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of node
+func (r *mmdbReader) record(node, bit uint) uint {
+	b := r.tree
+	switch r.recordSize {
+	case 24:
+		off := node*6 + bit*3
+		return uint(b[off])<<16 | uint(b[off+1])<<8 | uint(b[off+2])
+	case 28:
+		off := node * 7
+		if bit == 0 {
+			return uint(b[off+3]&0xf0)<<20 | uint(b[off])<<16 | uint(b[off+1])<<8 | uint(b[off+2])
+		}
+		return uint(b[off+3]&0x0f)<<24 | uint(b[off+4])<<16 | uint(b[off+5])<<8 | uint(b[off+6])
+	default:
+		off := node*8 + bit*4
+		return uint(binary.BigEndian.Uint32(b[off:]))
+	}
+}
+
+// lookup returns the record for ip, or nil when the database has none
+func (r *mmdbReader) lookup(ip net.IP) (interface{}, error) {
+	addr := ip.To4()
+	node := uint(0)
+	if addr != nil {
+		node = r.ipv4Start
+	} else if r.ipVersion == 4 {
+		return nil, nil
+	} else {
+		addr = ip.To16()
+	}
+	if addr == nil {
+		return nil, fmt.Errorf("invalid IP %v", ip)
+	}
+
+	for i := uint(0); i < uint(len(addr))*8 && node < r.nodeCount; i++ {
+		bit := uint(addr[i/8]>>(7-i%8)) & 1
+		node = r.record(node, bit)
+	}
+	switch {
+	case node == r.nodeCount:
+		return nil, nil
+	case node < r.nodeCount:
+		return nil, fmt.Errorf("%w: search tree deeper than the address", errMMDBCorrupt)
+	}
+	value, _, err := r.data.decode(node - r.nodeCount - 16)
+	return value, err
+}
+
+// mmdbDecoder decodes the data section format. Pointers are offsets into the same section.
+type mmdbDecoder []byte
+
+func (d mmdbDecoder) take(offset, n uint) ([]byte, error) {
+	if offset+n > uint(len(d)) || offset+n < offset {
+		return nil, fmt.Errorf("%w: data runs past the section", errMMDBCorrupt)
+	}
+	return d[offset : offset+n], nil
+}
+
+// decode returns the value at offset and the offset just past it
+func (d mmdbDecoder) decode(offset uint) (interface{}, uint, error) {
+	return d.decodeValue(offset, true)
+}
+
+func (d mmdbDecoder) decodeValue(offset uint, followPointer bool) (interface{}, uint, error) {
+	head, err := d.take(offset, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	ctrl := head[0]
+	offset++
+	kind := uint(ctrl >> 5)
+
+	if kind == 1 {
+		if !followPointer {
+			return nil, 0, fmt.Errorf("%w: pointer to a pointer", errMMDBCorrupt)
+		}
+		target, next, err := d.pointer(ctrl, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decodeValue(target, false)
+		return value, next, err
+	}
+
+	if kind == 0 {
+		ext, err := d.take(offset, 1)
+		if err != nil {
+			return nil, 0, err
+		}
+		kind = 7 + uint(ext[0])
+		offset++
+	}
+
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		extra := size - 28
+		b, err := d.take(offset, extra)
+		if err != nil {
+			return nil, 0, err
+		}
+		offset += extra
+		n := uint(0)
+		for _, c := range b {
+			n = n<<8 | uint(c)
+		}
+		size = []uint{29, 285, 65821}[extra-1] + n
+	}
+
+	switch kind {
+	case 7: // map
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("%w: map key is not a string", errMMDBCorrupt)
+			}
+			value, next, err := d.decode(next)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[name] = value
+			offset = next
+		}
+		return m, offset, nil
+	case 11: // array
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			value, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+			offset = next
+		}
+		return a, offset, nil
+	case 14: // boolean, the value is the size
+		return size != 0, offset, nil
+	}
+
+	b, err := d.take(offset, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	offset += size
+	switch kind {
+	case 2: // UTF-8 string
+		return string(b), offset, nil
+	case 3: // double
+		if size != 8 {
+			return nil, 0, fmt.Errorf("%w: double of %d bytes", errMMDBCorrupt, size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case 4: // bytes
+		return append([]byte(nil), b...), offset, nil
+	case 5, 6, 9, 10: // uint16, uint32, uint64, uint128 (only the low 64 bits are kept)
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		return n, offset, nil
+	case 8: // int32, shorter encodings are sign extended only at four bytes
+		var n uint32
+		for _, c := range b {
+			n = n<<8 | uint32(c)
+		}
+		return int64(int32(n)), offset, nil
+	case 15: // float
+		if size != 4 {
+			return nil, 0, fmt.Errorf("%w: float of %d bytes", errMMDBCorrupt, size)
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset, nil
+	}
+	return nil, 0, fmt.Errorf("%w: unknown type %d", errMMDBCorrupt, kind)
+}
+
+// pointer decodes the pointer whose control byte is ctrl and whose extra bytes start at offset
+func (d mmdbDecoder) pointer(ctrl byte, offset uint) (uint, uint, error) {
+	size := uint(ctrl>>3)&0x3 + 1
+	b, err := d.take(offset, size)
+	if err != nil {
+		return 0, 0, err
+	}
+	n := uint(0)
+	if size < 4 {
+		n = uint(ctrl & 0x7)
+	}
+	for _, c := range b {
+		n = n<<8 | uint(c)
+	}
+	n += []uint{0, 2048, 526336, 0}[size-1]
+	return n, offset + size, nil
+}
+
+// mmdbUint converts a decoded unsigned value, 0 for anything else
+func mmdbUint(v interface{}) uint64 {
+	n, _ := v.(uint64)
+	return n
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// mmdbWriter encodes values in the MaxMind DB data format for building test databases
+type mmdbWriter struct{ buf []byte }
+
+func (w *mmdbWriter) control(kind, size int) {
+	ctrl := byte(0)
+	if kind < 8 {
+		ctrl = byte(kind << 5)
+	}
+	switch {
+	case size < 29:
+		ctrl |= byte(size)
+		w.buf = append(w.buf, ctrl)
+	case size < 285:
+		w.buf = append(w.buf, ctrl|29)
+	default:
+		w.buf = append(w.buf, ctrl|30)
+	}
+	if kind >= 8 {
+		w.buf = append(w.buf, byte(kind-7))
+	}
+	switch {
+	case size >= 285:
+		w.buf = binary.BigEndian.AppendUint16(w.buf, uint16(size-285))
+	case size >= 29:
+		w.buf = append(w.buf, byte(size-29))
+	}
+}
+
+func (w *mmdbWriter) write(v interface{}) {
+	switch v := v.(type) {
+	case string:
+		w.control(2, len(v))
+		w.buf = append(w.buf, v...)
+	case uint32:
+		b := binary.BigEndian.AppendUint32(nil, v)
+		for len(b) > 0 && b[0] == 0 {
+			b = b[1:]
+		}
+		w.control(6, len(b))
+		w.buf = append(w.buf, b...)
+	case bool:
+		size := 0
+		if v {
+			size = 1
+		}
+		w.control(14, size)
+	case []interface{}:
+		w.control(11, len(v))
+		for _, item := range v {
+			w.write(item)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		w.control(7, len(keys))
+		for _, k := range keys {
+			w.write(k)
+			w.write(v[k])
+		}
+	}
+}
+
+// buildTestMMDB builds a database mapping each CIDR to its record. IPv4 networks in an
+// IPv6 database are stored under ::/96 like MaxMind does.
+func buildTestMMDB(t *testing.T, ipVersion, recordSize int, networks map[string]map[string]interface{}) []byte {
+	t.Helper()
+	type record struct {
+		node int // child node, or -1
+		data int // data offset, or -1
+	}
+	nodes := [][2]record{{{-1, -1}, {-1, -1}}}
+	var data mmdbWriter
+
+	for cidr, value := range networks {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ones, _ := network.Mask.Size()
+		addr := []byte(network.IP)
+		if ipVersion == 6 && len(addr) == 4 {
+			addr = append(make([]byte, 12), addr...)
+			ones += 96
+		} else if ipVersion == 6 {
+			addr = network.IP.To16()
+		}
+
+		offset := len(data.buf)
+		data.write(value)
+		node := 0
+		for i := 0; i < ones; i++ {
+			bit := (addr[i/8] >> (7 - i%8)) & 1
+			if i == ones-1 {
+				nodes[node][bit] = record{-1, offset}
+				break
+			}
+			if nodes[node][bit].node < 0 {
+				nodes = append(nodes, [2]record{{-1, -1}, {-1, -1}})
+				nodes[node][bit] = record{len(nodes) - 1, -1}
+			}
+			node = nodes[node][bit].node
+		}
+	}
+
+	count := len(nodes)
+	value := func(r record) uint32 {
+		switch {
+		case r.node >= 0:
+			return uint32(r.node)
+		case r.data >= 0:
+			return uint32(count + 16 + r.data)
+		}
+		return uint32(count)
+	}
+	var out []byte
+	for _, n := range nodes {
+		left, right := value(n[0]), value(n[1])
+		switch recordSize {
+		case 24:
+			out = append(out, byte(left>>16), byte(left>>8), byte(left), byte(right>>16), byte(right>>8), byte(right))
+		case 28:
+			out = append(out, byte(left>>16), byte(left>>8), byte(left), byte(left>>24)<<4|byte(right>>24)&0x0f, byte(right>>16), byte(right>>8), byte(right))
+		case 32:
+			out = binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(out, left), right)
+		}
+	}
+	out = append(out, make([]byte, 16)...)
+	out = append(out, data.buf...)
+	out = append(out, mmdbMetadataMarker...)
+
+	var meta mmdbWriter
+	meta.write(map[string]interface{}{
+		"node_count":                  uint32(count),
+		"record_size":                 uint32(recordSize),
+		"ip_version":                  uint32(ipVersion),
+		"database_type":               "Test-ASN",
+		"binary_format_major_version": uint32(2),
+		"languages":                   []interface{}{"en"},
+	})
+	return append(out, meta.buf...)
+}
+
+var testGeoNetworks = map[string]map[string]interface{}{
+	"185.51.200.0/22": {
+		"autonomous_system_number":       uint32(205585),
+		"autonomous_system_organization": "Shecan",
+		"country":                        map[string]interface{}{"iso_code": "IR", "names": map[string]interface{}{"en": "Iran"}},
+	},
+	"192.0.2.0/24": {
+		"autonomous_system_number": uint32(64500),
+		"isp":                      "Example ISP with a name longer than twenty-nine bytes",
+		"anycast":                  true,
+	},
+	"2001:db8::/32": {"autonomous_system_number": uint32(64501)},
+}
+
+func TestMMDBLookup(t *testing.T) {
+	for _, format := range []struct{ ipVersion, recordSize int }{{6, 24}, {6, 28}, {6, 32}, {4, 24}} {
+		networks := testGeoNetworks
+		if format.ipVersion == 4 {
+			networks = map[string]map[string]interface{}{"185.51.200.0/22": testGeoNetworks["185.51.200.0/22"]}
+		}
+		db, err := parseMMDB(buildTestMMDB(t, format.ipVersion, format.recordSize, networks))
+		if err != nil {
+			t.Fatalf("%+v: %v", format, err)
+		}
+
+		value, err := db.lookup(net.ParseIP("185.51.201.1"))
+		record, _ := value.(map[string]interface{})
+		if err != nil || mmdbUint(record["autonomous_system_number"]) != 205585 || record["autonomous_system_organization"] != "Shecan" {
+			t.Errorf("%+v: lookup = %v, %v", format, value, err)
+		}
+		if value, err := db.lookup(net.ParseIP("8.8.8.8")); value != nil || err != nil {
+			t.Errorf("%+v: unknown address = %v, %v", format, value, err)
+		}
+		if format.ipVersion == 6 {
+			value, err := db.lookup(net.ParseIP("2001:db8::1"))
+			if record, _ := value.(map[string]interface{}); err != nil || mmdbUint(record["autonomous_system_number"]) != 64501 {
+				t.Errorf("%+v: IPv6 lookup = %v, %v", format, value, err)
+			}
+		}
+	}
+}
+
+func TestMMDBRejectsGarbage(t *testing.T) {
+	if _, err := parseMMDB([]byte("not a database")); err == nil {
+		t.Error("expected an error without metadata")
+	}
+	valid := buildTestMMDB(t, 6, 24, testGeoNetworks)
+	if _, err := parseMMDB(valid[len(valid)/2:]); err == nil {
+		t.Error("expected an error for a truncated tree")
+	}
+}
+
+func TestAnnotateIPs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "asn.mmdb")
+	if err := os.WriteFile(path, buildTestMMDB(t, 6, 28, testGeoNetworks), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GEOIP_DB", path)
+
+	got := annotateIPs(loadGeoIPDatabases(), []string{"185.51.200.2", "192.0.2.10", "8.8.8.8", "not an ip"})
+	if len(got) != 2 {
+		t.Fatalf("annotations = %+v", got)
+	}
+	if a := got["185.51.200.2"]; a.ASN != 205585 || a.Country != "IR" || a.CountryName != "Iran" || a.String() != "AS205585 Shecan (IR)" {
+		t.Errorf("shecan = %+v (%s)", a, a)
+	}
+	if a := got["192.0.2.10"]; a.ASN != 64500 || a.ISP == "" {
+		t.Errorf("isp = %+v", a)
+	}
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Interface kinds
+const (
+	InterfaceLoopback = "loopback"
+	InterfaceTunnel   = "tunnel" // tun, tap, WireGuard and IPsec devices, usually a VPN
+	InterfacePPP      = "ppp"    // PPP links, a VPN or a PPPoE uplink
+	InterfacePhysical = "physical"
+)
+
+// tunnelPrefixes are interface name prefixes used by VPN software on Linux, macOS and Windows
+var tunnelPrefixes = []string{"tun", "tap", "wg", "utun", "ipsec", "gpd", "nordlynx", "proton", "tailscale", "zt"}
+
+var (
+	// route tables read on Linux, variables so tests can point them at fixtures
+	procNetRoute     = "/proc/net/route"
+	procNetIPv6Route = "/proc/net/ipv6_route"
+)
+
+// Route kinds, from most to least telling
+const (
+	RouteLookup  = "lookup"  // the route the system picks for a public address, policy routing included
+	RouteSplit   = "split"   // 0.0.0.0/1 and 128.0.0.0/1 (::/1 and 8000::/1), added by VPNs to override the default
+	RouteDefault = "default" // 0.0.0.0/0 or ::/0
+)
+
+// public addresses whose route is looked up; no packet is sent to them
+const (
+	routeProbeIPv4 = "1.1.1.1"
+	routeProbeIPv6 = "2606:4700:4700::1111"
+)
+
+// InterfaceInfo describes one network interface
+type InterfaceInfo struct {
+	Name         string   `json:"name"`
+	Index        int      `json:"index"`
+	MTU          int      `json:"mtu"`
+	HardwareAddr string   `json:"hardware_addr,omitempty"`
+	Flags        []string `json:"flags"`
+	IPv4         []string `json:"ipv4,omitempty"`
+	IPv6         []string `json:"ipv6,omitempty"`
+	Kind         string   `json:"kind"`
+	Tunnel       bool     `json:"tunnel"`
+}
+
+// RouteInfo is a route that carries traffic to the internet
+type RouteInfo struct {
+	Family    string `json:"family"` // ipv4 or ipv6
+	Kind      string `json:"kind"`
+	Interface string `json:"interface"`
+	Gateway   string `json:"gateway,omitempty"` // empty for point-to-point links
+	Metric    int    `json:"metric"`
+}
+
+// NetworkInventory is the local view of the network: interfaces, default routes and gateways
+type NetworkInventory struct {
+	Interfaces       []InterfaceInfo `json:"interfaces"`
+	DefaultRoutes    []RouteInfo     `json:"default_routes,omitempty"`
+	DefaultInterface string          `json:"default_interface,omitempty"`
+	Gateway          string          `json:"gateway,omitempty"`
+	GatewayIPv6      string          `json:"gateway_ipv6,omitempty"`
+	DefaultViaTunnel bool            `json:"default_via_tunnel"`
+	Tunnels          []string        `json:"tunnels,omitempty"` // tunnels in use, not idle system ones
+	Error            string          `json:"error,omitempty"`
+}
+
+// interfaceKind classifies an interface by its flags and name
+func interfaceKind(name string, flags net.Flags) string {
+	lower := strings.ToLower(name)
+	switch {
+	case flags&net.FlagLoopback != 0:
+		return InterfaceLoopback
+	case strings.HasPrefix(lower, "ppp"):
+		return InterfacePPP
+	}
+	for _, prefix := range tunnelPrefixes {
+		if strings.HasPrefix(lower, prefix) {
+			return InterfaceTunnel
+		}
+	}
+	if flags&net.FlagPointToPoint != 0 {
+		return InterfaceTunnel
+	}
+	return InterfacePhysical
+}
+
+func listInterfaces() ([]InterfaceInfo, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var list []InterfaceInfo
+	for _, iface := range ifaces {
+		info := InterfaceInfo{
+			Name:         iface.Name,
+			Index:        iface.Index,
+			MTU:          iface.MTU,
+			HardwareAddr: iface.HardwareAddr.String(),
+			Flags:        strings.Split(iface.Flags.String(), "|"),
+			Kind:         interfaceKind(iface.Name, iface.Flags),
+		}
+		info.Tunnel = info.Kind == InterfaceTunnel || info.Kind == InterfacePPP
+		if addrs, err := iface.Addrs(); err == nil {
+			for _, addr := range addrs {
+				ipnet, ok := addr.(*net.IPNet)
+				if !ok {
+					continue
+				}
+				if ipnet.IP.To4() != nil {
+					info.IPv4 = append(info.IPv4, ipnet.String())
+				} else {
+					info.IPv6 = append(info.IPv6, ipnet.String())
+				}
+			}
+		}
+		list = append(list, info)
+	}
+	return list, nil
+}
+
+// joinSplitRoutes returns a split default route for every interface holding both halves
+func joinSplitRoutes(low, high []RouteInfo) []RouteInfo {
+	var routes []RouteInfo
+	for _, l := range low {
+		for _, h := range high {
+			if l.Interface == h.Interface {
+				l.Kind = RouteSplit
+				routes = append(routes, l)
+				break
+			}
+		}
+	}
+	return routes
+}
+
+// parseProcNetRoute returns the IPv4 default and split default routes of
+// /proc/net/route, whose addresses are little-endian hex
+func parseProcNetRoute(content string) []RouteInfo {
+	var routes, low, high []RouteInfo
+	for _, line := range strings.Split(content, "\n")[1:] {
+		fields := strings.Fields(line)
+		if len(fields) < 8 {
+			continue
+		}
+		flags, _ := strconv.ParseUint(fields[3], 16, 32)
+		if flags&0x1 == 0 { // RTF_UP
+			continue
+		}
+		route := RouteInfo{Family: "ipv4", Kind: RouteDefault, Interface: fields[0]}
+		route.Metric, _ = strconv.Atoi(fields[6])
+		if gw, err := strconv.ParseUint(fields[2], 16, 32); err == nil && gw != 0 {
+			ip := make(net.IP, 4)
+			binary.LittleEndian.PutUint32(ip, uint32(gw))
+			route.Gateway = ip.String()
+		}
+		switch fields[1] + "/" + fields[7] {
+		case "00000000/00000000":
+			routes = append(routes, route)
+		case "00000000/00000080": // 0.0.0.0/1
+			low = append(low, route)
+		case "00000080/00000080": // 128.0.0.0/1
+			high = append(high, route)
+		}
+	}
+	return append(routes, joinSplitRoutes(low, high)...)
+}
+
+// parseProcNetIPv6Route returns the IPv6 default and split default routes of /proc/net/ipv6_route
+func parseProcNetIPv6Route(content string) []RouteInfo {
+	var routes, low, high []RouteInfo
+	zero := strings.Repeat("0", 32)
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 10 || fields[9] == "lo" {
+			continue
+		}
+		flags, _ := strconv.ParseUint(fields[8], 16, 32)
+		if flags&0x1 == 0 || flags&0x200 != 0 { // RTF_UP, RTF_REJECT
+			continue
+		}
+		route := RouteInfo{Family: "ipv6", Kind: RouteDefault, Interface: fields[9]}
+		metric, _ := strconv.ParseUint(fields[5], 16, 32)
+		route.Metric = int(metric)
+		if gw, err := hex.DecodeString(fields[4]); err == nil && len(gw) == 16 && !net.IP(gw).IsUnspecified() {
+			route.Gateway = net.IP(gw).String()
+		}
+		switch fields[0] + "/" + fields[1] {
+		case zero + "/00":
+			routes = append(routes, route)
+		case zero + "/01": // ::/1
+			low = append(low, route)
+		case "8" + zero[1:] + "/01": // 8000::/1
+			high = append(high, route)
+		}
+	}
+	return append(routes, joinSplitRoutes(low, high)...)
+}
+
+// readProcRoutes reads the default routes of both families from /proc
+func readProcRoutes() ([]RouteInfo, error) {
+	data, err := os.ReadFile(procNetRoute)
+	if err != nil {
+		return nil, err
+	}
+	routes := parseProcNetRoute(string(data))
+	if data, err := os.ReadFile(procNetIPv6Route); err == nil {
+		routes = append(routes, parseProcNetIPv6Route(string(data))...)
+	}
+	return routes, nil
+}
+
+var (
+	macRouteGatewayPattern   = regexp.MustCompile(`(?m)^\s*gateway: (\S+)`)
+	macRouteInterfacePattern = regexp.MustCompile(`(?m)^\s*interface: (\S+)`)
+	windowsRoutePattern      = regexp.MustCompile(`(?m)^\s*(0\.0\.0\.0|128\.0\.0\.0)\s+(0\.0\.0\.0|128\.0\.0\.0)\s+(\S+)\s+(\S+)\s+(\d+)`)
+)
+
+// parseMacRouteGet reads the output of "route -n get <public address>"
+func parseMacRouteGet(output, family string) []RouteInfo {
+	iface := macRouteInterfacePattern.FindStringSubmatch(output)
+	if iface == nil {
+		return nil
+	}
+	route := RouteInfo{Family: family, Kind: RouteLookup, Interface: iface[1]}
+	if gw := macRouteGatewayPattern.FindStringSubmatch(output); gw != nil {
+		// link-local gateways carry a zone, e.g. fe80::1%en0
+		route.Gateway = strings.SplitN(gw[1], "%", 2)[0]
+	}
+	return []RouteInfo{route}
+}
+
+// parseWindowsRoutePrint reads the IPv4 default and split default routes of "route print -4".
+// Windows names the interface by its address, which is mapped back to a name.
+func parseWindowsRoutePrint(output string, interfaces []InterfaceInfo) []RouteInfo {
+	var routes, low, high []RouteInfo
+	for _, m := range windowsRoutePattern.FindAllStringSubmatch(output, -1) {
+		route := RouteInfo{Family: "ipv4", Kind: RouteDefault, Interface: m[4]}
+		if ip := net.ParseIP(m[3]); ip != nil {
+			route.Gateway = ip.String()
+		}
+		route.Metric, _ = strconv.Atoi(m[5])
+		for _, iface := range interfaces {
+			for _, cidr := range iface.IPv4 {
+				if strings.SplitN(cidr, "/", 2)[0] == m[4] {
+					route.Interface = iface.Name
+				}
+			}
+		}
+		switch m[1] + "/" + m[2] {
+		case "0.0.0.0/0.0.0.0":
+			routes = append(routes, route)
+		case "0.0.0.0/128.0.0.0":
+			low = append(low, route)
+		case "128.0.0.0/128.0.0.0":
+			high = append(high, route)
+		}
+	}
+	return append(routes, joinSplitRoutes(low, high)...)
+}
+
+func defaultRoutes(interfaces []InterfaceInfo) ([]RouteInfo, error) {
+	switch runtime.GOOS {
+	case "linux":
+		routes, err := readProcRoutes()
+		if err != nil {
+			return nil, err
+		}
+		// wg-quick and other policy routing keep their default in a separate table,
+		// which only a lookup shows
+		for _, probe := range []string{routeProbeIPv4, routeProbeIPv6} {
+			if route, err := lookupRoute(net.ParseIP(probe)); err == nil {
+				routes = append(routes, route)
+			}
+		}
+		return routes, nil
+	case "darwin":
+		var routes []RouteInfo
+		result, err := runCommand(5*time.Second, "route", "-n", "get", routeProbeIPv4)
+		if err != nil {
+			return nil, err
+		}
+		routes = parseMacRouteGet(result.Stdout, "ipv4")
+		if result, err := runCommand(5*time.Second, "route", "-n", "get", "-inet6", routeProbeIPv6); err == nil {
+			routes = append(routes, parseMacRouteGet(result.Stdout, "ipv6")...)
+		}
+		return routes, nil
+	case "windows":
+		result, err := runCommand(10*time.Second, "route", "print", "-4")
+		if err != nil {
+			return nil, err
+		}
+		return parseWindowsRoutePrint(result.Stdout, interfaces), nil
+	}
+	return nil, fmt.Errorf("default routes are not read on %s", runtime.GOOS)
+}
+
+// collectNetworkInventory lists the interfaces and default routes and marks tunnels
+func collectNetworkInventory() *NetworkInventory {
+	inv := &NetworkInventory{}
+	interfaces, err := listInterfaces()
+	if err != nil {
+		inv.Error = err.Error()
+		return inv
+	}
+	inv.Interfaces = interfaces
+
+	routes, err := defaultRoutes(interfaces)
+	if err != nil {
+		inv.Error = err.Error()
+	}
+	inv.DefaultRoutes = routes
+	inv.summarize()
+	return inv
+}
+
+// routeRank orders route kinds: a lookup is what the system really does, a split
+// default wins over the default by its longer prefix
+var routeRank = map[string]int{RouteLookup: 0, RouteSplit: 1, RouteDefault: 2}
+
+// summarize picks the route each family really uses and collects the tunnels
+func (inv *NetworkInventory) summarize() {
+	best := map[string]*RouteInfo{}
+	for i := range inv.DefaultRoutes {
+		route := &inv.DefaultRoutes[i]
+		current := best[route.Family]
+		if current == nil || routeRank[route.Kind] < routeRank[current.Kind] ||
+			(routeRank[route.Kind] == routeRank[current.Kind] && route.Metric < current.Metric) {
+			best[route.Family] = route
+		}
+	}
+	if route := best["ipv4"]; route != nil {
+		inv.DefaultInterface = route.Interface
+		inv.Gateway = route.Gateway
+	}
+	if route := best["ipv6"]; route != nil {
+		inv.GatewayIPv6 = route.Gateway
+		if inv.DefaultInterface == "" {
+			inv.DefaultInterface = route.Interface
+		}
+	}
+	tunnels := map[string]bool{}
+	for _, iface := range inv.Interfaces {
+		if iface.Tunnel && contains(iface.Flags, "up") {
+			tunnels[iface.Name] = true
+		}
+	}
+	routed := map[string]bool{}
+	for _, route := range inv.DefaultRoutes {
+		routed[route.Interface] = true
+	}
+	for _, route := range best {
+		if tunnels[route.Interface] || interfaceKind(route.Interface, 0) == InterfaceTunnel {
+			inv.DefaultViaTunnel = true
+			inv.DefaultInterface = route.Interface
+		}
+	}
+
+	// macOS keeps utun0-3 up for system services with link-local addresses only,
+	// so a tunnel counts when it carries a default route or has a routable address
+	for _, iface := range inv.Interfaces {
+		if tunnels[iface.Name] && (routed[iface.Name] || iface.routable()) {
+			inv.Tunnels = append(inv.Tunnels, iface.Name)
+		}
+	}
+}
+
+// routable reports whether the interface has an address beyond link-local ones
+func (i InterfaceInfo) routable() bool {
+	for _, cidr := range append(append([]string{}, i.IPv4...), i.IPv6...) {
+		ip, _, err := net.ParseCIDR(cidr)
+		if err == nil && !ip.IsLinkLocalUnicast() && !ip.IsLoopback() {
+			return true
+		}
+	}
+	return false
+}
+
+func printNetworkInventory(inv *NetworkInventory) {
+	if inv.DefaultInterface == "" {
+		fmt.Println(colorMap["yellow"], "[Warning] No default route found")
+		return
+	}
+	gateway := inv.Gateway
+	if gateway == "" {
+		gateway = "none"
+	}
+	fmt.Printf("%s [INFO] Default route via %s, gateway %s\n", colorMap["blue"], inv.DefaultInterface, gateway)
+	if inv.DefaultViaTunnel {
+		fmt.Println(colorMap["yellow"], "[Warning] The default route goes through a tunnel interface, a VPN is probably active")
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"syscall"
+	"time"
+)
+
+// lookupRoute asks the kernel over netlink (RTM_GETROUTE) which route it would use for dst,
+// the way "ip route get" does. Unlike /proc/net/route this follows policy routing rules.
+func lookupRoute(dst net.IP) (RouteInfo, error) {
+	family, addr := syscall.AF_INET, dst.To4()
+	route := RouteInfo{Family: "ipv4", Kind: RouteLookup}
+	if addr == nil {
+		family, addr = syscall.AF_INET6, dst.To16()
+		route.Family = "ipv6"
+	}
+
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return route, err
+	}
+	defer syscall.Close(fd)
+	timeout := syscall.NsecToTimeval(int64(2 * time.Second))
+	syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &timeout)
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return route, err
+	}
+
+	// nlmsghdr, rtmsg and a single RTA_DST attribute
+	attrLen := syscall.SizeofRtAttr + len(addr)
+	msg := make([]byte, syscall.SizeofNlMsghdr+syscall.SizeofRtMsg+(attrLen+3)&^3)
+	binary.NativeEndian.PutUint32(msg[0:], uint32(len(msg)))
+	binary.NativeEndian.PutUint16(msg[4:], syscall.RTM_GETROUTE)
+	binary.NativeEndian.PutUint16(msg[6:], syscall.NLM_F_REQUEST)
+	binary.NativeEndian.PutUint32(msg[8:], 1)
+	rtm := msg[syscall.SizeofNlMsghdr:]
+	rtm[0] = byte(family)
+	rtm[1] = byte(len(addr) * 8)
+	attr := rtm[syscall.SizeofRtMsg:]
+	binary.NativeEndian.PutUint16(attr[0:], uint16(attrLen))
+	binary.NativeEndian.PutUint16(attr[2:], syscall.RTA_DST)
+	copy(attr[syscall.SizeofRtAttr:], addr)
+	if err := syscall.Sendto(fd, msg, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return route, err
+	}
+
+	buf := make([]byte, 8192)
+	n, _, err := syscall.Recvfrom(fd, buf, 0)
+	if err != nil {
+		return route, err
+	}
+	messages, err := syscall.ParseNetlinkMessage(buf[:n])
+	if err != nil {
+		return route, err
+	}
+	for _, m := range messages {
+		switch m.Header.Type {
+		case syscall.NLMSG_ERROR:
+			if len(m.Data) >= 4 {
+				if errno := int32(binary.NativeEndian.Uint32(m.Data)); errno != 0 {
+					return route, syscall.Errno(-errno)
+				}
+			}
+		case syscall.RTM_NEWROUTE:
+			attrs, err := syscall.ParseNetlinkRouteAttr(&m)
+			if err != nil {
+				return route, err
+			}
+			for _, a := range attrs {
+				switch a.Attr.Type {
+				case syscall.RTA_OIF:
+					if iface, err := net.InterfaceByIndex(int(binary.NativeEndian.Uint32(a.Value))); err == nil {
+						route.Interface = iface.Name
+					}
+				case syscall.RTA_GATEWAY:
+					route.Gateway = net.IP(a.Value).String()
+				case syscall.RTA_PRIORITY:
+					route.Metric = int(binary.NativeEndian.Uint32(a.Value))
+				}
+			}
+			if route.Interface == "" {
+				return route, errors.New("route without an outgoing interface")
+			}
+			return route, nil
+		}
+	}
+	return route, errors.New("no route in the netlink answer")
+}
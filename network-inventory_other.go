@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// lookupRoute is only implemented over netlink; other systems ask the route command
+func lookupRoute(dst net.IP) (RouteInfo, error) {
+	return RouteInfo{}, errors.New("route lookup is not supported on this system")
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+const recordedProcNetRoute = `Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT                                                       
+wlan0	00000000	0101A8C0	0003	0	0	600	00000000	0	0	0                                                                               
+tun0	00000000	00000000	0001	0	0	50	00000000	0	0	0                                                                               
+wlan0	0001A8C0	00000000	0001	0	0	600	00FFFFFF	0	0	0                                                                               
+`
+
+const recordedProcNetIPv6Route = `00000000000000000000000000000000 00 00000000000000000000000000000000 00 fe800000000000000000000000000001 00000400 00000001 00000000 00000003     wlan0
+fd000000000000000000000000000000 40 00000000000000000000000000000000 00 00000000000000000000000000000000 00000100 00000001 00000000 00000001     wlan0
+00000000000000000000000000000000 00 00000000000000000000000000000000 00 00000000000000000000000000000000 ffffffff 00000001 00000000 00200200       lo
+`
+
+// an OpenVPN client with redirect-gateway def1: the real default stays, two /1 routes override it
+const recordedOpenVPNRoute = `Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+wlan0	00000000	0101A8C0	0003	0	0	600	00000000	0	0	0
+tun0	00000000	0108080A	0003	0	0	0	00000080	0	0	0
+tun0	00000080	0108080A	0003	0	0	0	00000080	0	0	0
+tun0	0008080A	00000000	0001	0	0	0	00FFFFFF	0	0	0
+`
+
+const recordedOpenVPNIPv6Route = `00000000000000000000000000000000 00 00000000000000000000000000000000 00 fe800000000000000000000000000001 00000400 00000001 00000000 00000003     wlan0
+00000000000000000000000000000000 01 00000000000000000000000000000000 00 00000000000000000000000000000000 00000400 00000001 00000000 00000001      tun0
+80000000000000000000000000000000 01 00000000000000000000000000000000 00 00000000000000000000000000000000 00000400 00000001 00000000 00000001      tun0
+`
+
+func TestParseDefaultRoutes(t *testing.T) {
+	want := []RouteInfo{
+		{Family: "ipv4", Kind: RouteDefault, Interface: "wlan0", Gateway: "192.168.1.1", Metric: 600},
+		{Family: "ipv4", Kind: RouteDefault, Interface: "tun0", Metric: 50},
+	}
+	if got := parseProcNetRoute(recordedProcNetRoute); !reflect.DeepEqual(got, want) {
+		t.Errorf("ipv4 routes: %+v", got)
+	}
+	want = []RouteInfo{{Family: "ipv6", Kind: RouteDefault, Interface: "wlan0", Gateway: "fe80::1", Metric: 1024}}
+	if got := parseProcNetIPv6Route(recordedProcNetIPv6Route); !reflect.DeepEqual(got, want) {
+		t.Errorf("ipv6 routes: %+v", got)
+	}
+
+	mac := "   route to: one.one.one.one\ndestination: default\n    gateway: 10.0.0.1\n  interface: en0\n"
+	if got := parseMacRouteGet(mac, "ipv4"); len(got) != 1 || got[0].Gateway != "10.0.0.1" || got[0].Interface != "en0" || got[0].Kind != RouteLookup {
+		t.Errorf("route get: %+v", got)
+	}
+
+	windows := "Network Destination        Netmask          Gateway       Interface  Metric\n" +
+		"          0.0.0.0          0.0.0.0      192.168.1.1    192.168.1.20     25\n" +
+		"          0.0.0.0        128.0.0.0         On-link        10.8.0.6    281\n" +
+		"        128.0.0.0        128.0.0.0         On-link        10.8.0.6    281\n"
+	interfaces := []InterfaceInfo{{Name: "Wi-Fi", IPv4: []string{"192.168.1.20/24"}}, {Name: "OpenVPN TAP", IPv4: []string{"10.8.0.6/24"}}}
+	want = []RouteInfo{
+		{Family: "ipv4", Kind: RouteDefault, Interface: "Wi-Fi", Gateway: "192.168.1.1", Metric: 25},
+		{Family: "ipv4", Kind: RouteSplit, Interface: "OpenVPN TAP", Metric: 281},
+	}
+	if got := parseWindowsRoutePrint(windows, interfaces); !reflect.DeepEqual(got, want) {
+		t.Errorf("route print: %+v", got)
+	}
+}
+
+func TestReadSplitRoutes(t *testing.T) {
+	dir := t.TempDir()
+	procNetRoute, procNetIPv6Route = filepath.Join(dir, "route"), filepath.Join(dir, "ipv6_route")
+	t.Cleanup(func() { procNetRoute, procNetIPv6Route = "/proc/net/route", "/proc/net/ipv6_route" })
+	if err := os.WriteFile(procNetRoute, []byte(recordedOpenVPNRoute), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(procNetIPv6Route, []byte(recordedOpenVPNIPv6Route), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	routes, err := readProcRoutes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []RouteInfo{
+		{Family: "ipv4", Kind: RouteDefault, Interface: "wlan0", Gateway: "192.168.1.1", Metric: 600},
+		{Family: "ipv4", Kind: RouteSplit, Interface: "tun0", Gateway: "10.8.8.1"},
+		{Family: "ipv6", Kind: RouteDefault, Interface: "wlan0", Gateway: "fe80::1", Metric: 1024},
+		{Family: "ipv6", Kind: RouteSplit, Interface: "tun0", Metric: 1024},
+	}
+	if !reflect.DeepEqual(routes, want) {
+		t.Fatalf("routes: %+v", routes)
+	}
+
+	// the split routes carry the traffic although the default has a metric too
+	inv := &NetworkInventory{
+		Interfaces: []InterfaceInfo{
+			{Name: "wlan0", Flags: []string{"up", "broadcast"}, Kind: InterfacePhysical},
+			{Name: "tun0", Flags: []string{"up", "pointtopoint"}, Kind: InterfaceTunnel, Tunnel: true},
+		},
+		DefaultRoutes: routes,
+	}
+	inv.summarize()
+	if inv.DefaultInterface != "tun0" || inv.Gateway != "10.8.8.1" || !inv.DefaultViaTunnel {
+		t.Errorf("summary: %+v", inv)
+	}
+}
+
+func TestNetworkInventorySummary(t *testing.T) {
+	for name, tc := range map[string]struct {
+		iface string
+		flags net.Flags
+		kind  string
+	}{
+		"loopback":  {"lo", net.FlagUp | net.FlagLoopback, InterfaceLoopback},
+		"wireguard": {"wg0", net.FlagUp, InterfaceTunnel},
+		"macos vpn": {"utun3", net.FlagUp | net.FlagPointToPoint, InterfaceTunnel},
+		"pppoe":     {"ppp0", net.FlagUp | net.FlagPointToPoint, InterfacePPP},
+		"wifi":      {"wlan0", net.FlagUp | net.FlagBroadcast, InterfacePhysical},
+	} {
+		if got := interfaceKind(tc.iface, tc.flags); got != tc.kind {
+			t.Errorf("%s: kind %s", name, got)
+		}
+	}
+
+	interfaces := []InterfaceInfo{
+		{Name: "wlan0", Flags: []string{"up", "broadcast"}, Kind: InterfacePhysical},
+		{Name: "wg0", Flags: []string{"up", "pointtopoint"}, IPv4: []string{"10.66.0.2/32"}, Kind: InterfaceTunnel, Tunnel: true},
+		{Name: "utun0", Flags: []string{"up", "pointtopoint"}, IPv6: []string{"fe80::ce81:b1c:bd2c:69e/64"}, Kind: InterfaceTunnel, Tunnel: true},
+	}
+	inv := &NetworkInventory{Interfaces: interfaces, DefaultRoutes: parseProcNetRoute(recordedProcNetRoute)[:1]}
+	inv.summarize()
+	if inv.DefaultInterface != "wlan0" || inv.Gateway != "192.168.1.1" || inv.DefaultViaTunnel {
+		t.Errorf("summary: %+v", inv)
+	}
+	if !reflect.DeepEqual(inv.Tunnels, []string{"wg0"}) {
+		t.Errorf("tunnels: %v", inv.Tunnels)
+	}
+
+	// wg-quick keeps its default in a policy table: the main table still points at
+	// wlan0, only the lookup shows the tunnel
+	inv = &NetworkInventory{Interfaces: interfaces, DefaultRoutes: append(parseProcNetRoute(recordedProcNetRoute)[:1],
+		RouteInfo{Family: "ipv4", Kind: RouteLookup, Interface: "wg0"})}
+	inv.summarize()
+	if inv.DefaultInterface != "wg0" || inv.Gateway != "" || !inv.DefaultViaTunnel {
+		t.Errorf("summary: %+v", inv)
+	}
+}
+
+func TestLookupRouteLoopback(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("netlink route lookups are Linux only")
+	}
+	route, err := lookupRoute(net.ParseIP("127.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iface, err := net.InterfaceByName(route.Interface); err != nil || iface.Flags&net.FlagLoopback == 0 {
+		t.Errorf("route = %+v", route)
+	}
+}
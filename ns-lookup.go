@@ -1,10 +1,7 @@
 package main
 
 import (
-	"bytes"
-	"context"
 	"fmt"
-	"os/exec"
 	"strings"
 	"time"
 )
@@ -19,28 +16,20 @@ type DNSRecord struct {
 	Error    string `json:"error,omitempty"` // Stores errors if the lookup fails
 }
 
-// RunCommand executes a shell command with a timeout
+// RunCommand executes a command with a timeout and returns its combined output
 func RunCommand(timeout time.Duration, command string, args ...string) (string, error) {
 	fmt.Println(colorMap["blue"], "[INFO] Running command:", command, args, colorMap["reset"])
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, command, args...)
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	cmd.Stderr = &out
-
-	err := cmd.Run()
-	if ctx.Err() == context.DeadlineExceeded {
+	result, err := runCommand(timeout, command, args...)
+	if err == errCommandTimeout {
 		fmt.Println(colorMap["red"], "[ERROR] Command timed out", colorMap["reset"])
-		return "", fmt.Errorf("command timed out")
+		return "", err
 	}
 
 	if err != nil {
 		fmt.Println(colorMap["red"], "[ERROR] Command execution failed:", err, colorMap["reset"])
 	}
-	return out.String(), err
+	return result.Output(), err
 }
 
 // ParseNslookupOutput parses `nslookup` output and extracts resolver, address, and errors
@@ -53,11 +42,19 @@ func ParseNslookupOutput(output, domain string) ([]DNSRecord, error) {
 	var foundResolver string
 	var resolvedValues []string
 
+	resolverAddressPending := false
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
 		if strings.HasPrefix(line, "Server:") {
 			foundResolver = strings.Fields(line)[1] // Extract resolver name or IP
 			fmt.Println(colorMap["green"], "[INFO] Detected resolver:", foundResolver, colorMap["reset"])
+			resolverAddressPending = true
+			continue
+		}
+		// the Address line right after Server: is the resolver itself, not an answer
+		if resolverAddressPending && strings.HasPrefix(line, "Address:") {
+			resolverAddressPending = false
+			continue
 		}
 		if strings.Contains(line, "Address:") && !strings.HasPrefix(line, "Server:") {
 			fields := strings.Fields(line)
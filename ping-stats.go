@@ -0,0 +1,97 @@
+package main
+
+import "math"
+
+// PingStats summarises a ping run for the report. RTT fields are nil when they were not
+// measured: no reply came back, or the ping binary only printed its summary line.
+type PingStats struct {
+	Method      string    `json:"method"`
+	Address     string    `json:"address,omitempty"`
+	Sent        int       `json:"sent"`
+	Received    int       `json:"received"`
+	LossPct     float64   `json:"loss_pct"`
+	MinMs       *float64  `json:"min_ms,omitempty"`
+	AvgMs       *float64  `json:"avg_ms,omitempty"`
+	MaxMs       *float64  `json:"max_ms,omitempty"`
+	StddevMs    *float64  `json:"stddev_ms,omitempty"`
+	JitterMs    *float64  `json:"jitter_ms,omitempty"` // mean difference between consecutive RTTs
+	TTL         int       `json:"ttl,omitempty"`
+	RTTs        []float64 `json:"rtts_ms,omitempty"`
+	LostSeqs    []int     `json:"lost_seqs,omitempty"`
+	SummaryOnly bool      `json:"summary_only,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+func newPingStats(r PingResult) PingStats {
+	stats := PingStats{
+		Method:      r.Method,
+		Address:     r.Address,
+		Sent:        r.Sent,
+		Received:    r.Received,
+		TTL:         r.TTL,
+		RTTs:        r.RTTs,
+		LostSeqs:    r.LostSeqs,
+		SummaryOnly: r.SummaryOnly,
+		Error:       r.Error,
+	}
+	if r.Sent > 0 {
+		stats.LossPct = round2(float64(r.Sent-r.Received) / float64(r.Sent) * 100)
+		if stats.LossPct < 0 {
+			stats.LossPct = 0
+		}
+	}
+	if r.SummaryOnly && r.Received > 0 {
+		stats.AvgMs = measured(round2(r.SummaryAvg))
+	}
+	if len(r.RTTs) == 0 {
+		return stats
+	}
+
+	minRTT, maxRTT := r.RTTs[0], r.RTTs[0]
+	var sum float64
+	for _, rtt := range r.RTTs {
+		sum += rtt
+		minRTT = math.Min(minRTT, rtt)
+		maxRTT = math.Max(maxRTT, rtt)
+	}
+	avg := sum / float64(len(r.RTTs))
+
+	var variance, jitter float64
+	for i, rtt := range r.RTTs {
+		variance += (rtt - avg) * (rtt - avg)
+		if i > 0 {
+			jitter += math.Abs(rtt - r.RTTs[i-1])
+		}
+	}
+	stats.MinMs, stats.MaxMs = measured(minRTT), measured(maxRTT)
+	stats.AvgMs = measured(round2(avg))
+	stats.StddevMs = measured(round2(math.Sqrt(variance / float64(len(r.RTTs)))))
+	if len(r.RTTs) > 1 {
+		stats.JitterMs = measured(round2(jitter / float64(len(r.RTTs)-1)))
+	}
+	return stats
+}
+
+// avg is the average RTT, 0 when it was not measured
+func (s PingStats) avg() float64 {
+	if s.AvgMs == nil {
+		return 0
+	}
+	return *s.AvgMs
+}
+
+// legacyAvg is the average RTT as the old report showed it, -1 when nothing came back
+func (s PingStats) legacyAvg() float64 {
+	if s.Received == 0 || s.AvgMs == nil {
+		return -1
+	}
+	return *s.AvgMs
+}
+
+func measured(v float64) *float64 {
+	return &v
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNewPingStats(t *testing.T) {
+	stats := newPingStats(PingResult{Method: PingMethodRaw, Sent: 4, Received: 3, RTTs: []float64{10, 20, 15}, LostSeqs: []int{2}})
+	if stats.LossPct != 25 || *stats.MinMs != 10 || *stats.MaxMs != 20 || *stats.AvgMs != 15 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if *stats.StddevMs != 4.08 || *stats.JitterMs != 7.5 {
+		t.Errorf("stddev/jitter = %v/%v", *stats.StddevMs, *stats.JitterMs)
+	}
+	if stats.legacyAvg() != 15 {
+		t.Errorf("legacyAvg = %v", stats.legacyAvg())
+	}
+
+	failed := newPingStats(PingResult{Method: PingMethodRaw, Sent: 4})
+	if failed.LossPct != 100 || failed.AvgMs != nil || failed.legacyAvg() != -1 {
+		t.Errorf("failed stats = %+v", failed)
+	}
+
+	// a loopback reply can take 0 ms, which is a measurement and stays in the report
+	data, _ := json.Marshal(newPingStats(PingResult{Method: PingMethodRaw, Sent: 1, Received: 1, RTTs: []float64{0}}))
+	if !strings.Contains(string(data), `"min_ms":0,"avg_ms":0,"max_ms":0,"stddev_ms":0`) {
+		t.Errorf("zero RTT dropped: %s", data)
+	}
+}
+
+func TestPingSummaryOnly(t *testing.T) {
+	output := "PING 178.22.122.100 (178.22.122.100) 56(84) bytes of data.\n\n" +
+		"--- 178.22.122.100 ping statistics ---\n" +
+		"4 packets transmitted, 3 received, 25% packet loss, time 3004ms\n" +
+		"rtt min/avg/max/mdev = 41.210/43.602/46.870/2.391 ms\n"
+	useScriptedRunner(t, newScriptedRunner(map[string]CommandResult{"ping": {Stdout: output}}))
+
+	result, err := binaryPing("178.22.122.100", 4, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := newPingStats(result)
+	if !stats.SummaryOnly || stats.Received != 3 || stats.LossPct != 25 || len(stats.RTTs) != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if stats.avg() != 43.6 || stats.MinMs != nil || stats.MaxMs != nil || stats.StddevMs != nil || stats.JitterMs != nil {
+		t.Errorf("only the average is known: %+v", stats)
+	}
+}
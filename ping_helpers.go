@@ -20,6 +20,12 @@ func markHTTPReachable(host string) {
 	httpReachableMu.Unlock()
 }
 
+func resetHTTPReachable() {
+	httpReachableMu.Lock()
+	httpReachableHosts = map[string]struct{}{}
+	httpReachableMu.Unlock()
+}
+
 func isHTTPReachable(host string) bool {
 	host = strings.TrimSpace(host)
 	if host == "" {
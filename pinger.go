@@ -1,44 +1,127 @@
 package main
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
-	"os/exec"
+	"math"
+	"os"
 	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 const maxPingConcurrency = 4
 
 var pingMu sync.Mutex
 
-func pingServer(server string, count int, timeout int) (float64, error) {
+func pingServer(server string, count int, timeout int) (PingStats, error) {
+	result, err := probePing(server, count, timeout)
+	if err == nil && result.Received == 0 {
+		err = fmt.Errorf("no reply from %s", server)
+	}
+	stats := newPingStats(result)
+	if err != nil {
+		fmt.Println("Error running ping command:", err)
+		stats.Error = err.Error()
+	}
+	return stats, err
+}
+
+// probePing uses the native ICMP engine and falls back to the ping binary only when no
+// ICMP socket can be opened or the target is not IPv4. PING_ENGINE=binary forces the binary.
+func probePing(server string, count int, timeout int) (PingResult, error) {
+	if os.Getenv("PING_ENGINE") != PingMethodBinary {
+		result, err := icmpPing(server, count, time.Duration(timeout)*time.Second)
+		if err == nil || !(errors.Is(err, errNoICMPSocket) || errors.Is(err, errNativeUnsupported)) {
+			if err != nil {
+				result.Error = err.Error()
+			}
+			return result, err
+		}
+	}
+	return binaryPing(server, count, timeout)
+}
+
+var pingReplyPattern = regexp.MustCompile(`(?i)(?:icmp_seq=(\d+) )?ttl=(\d+) time[=<]([\d.]+) ?ms`)
+var pingLossPattern = regexp.MustCompile(`([\d.]+)% (?:packet )?loss`)
+var pingReplyWindowsPattern = regexp.MustCompile(`(?i)time[=<]([\d.]+)ms ttl=(\d+)`)
 
-	var cmd *exec.Cmd
+// binaryPing runs the system ping command and scrapes what it can from the output
+func binaryPing(server string, count int, timeout int) (PingResult, error) {
+	var args []string
 
-	// Choose appropriate ping command based on OS
+	// Choose appropriate ping arguments based on OS
 	switch runtime.GOOS {
 	case "windows":
-		cmd = exec.Command("ping", "-n", strconv.Itoa(count), "-w", strconv.Itoa(timeout*1000), server)
+		args = []string{"-n", strconv.Itoa(count), "-w", strconv.Itoa(timeout * 1000), server}
 	default: // Linux & macOS
-		cmd = exec.Command("ping", "-c", strconv.Itoa(count), "-W", strconv.Itoa(timeout), server)
+		args = []string{"-c", strconv.Itoa(count), "-W", strconv.Itoa(timeout), server}
+	}
+
+	result := PingResult{Method: PingMethodBinary, Sent: count}
+
+	// Allow every probe its full timeout plus some slack for process start-up
+	out, err := runCommand(time.Duration(count*timeout+5)*time.Second, "ping", args...)
+	output := out.Output()
+	seen := map[int]bool{}
+	for _, line := range strings.Split(output, "\n") {
+		if m := pingReplyPattern.FindStringSubmatch(line); m != nil {
+			rtt, _ := strconv.ParseFloat(m[3], 64)
+			result.RTTs = append(result.RTTs, rtt)
+			result.TTL, _ = strconv.Atoi(m[2])
+			if m[1] != "" {
+				seq, _ := strconv.Atoi(m[1])
+				seen[seq] = true
+			}
+		} else if m := pingReplyWindowsPattern.FindStringSubmatch(line); m != nil {
+			rtt, _ := strconv.ParseFloat(m[1], 64)
+			result.RTTs = append(result.RTTs, rtt)
+			result.TTL, _ = strconv.Atoi(m[2])
+		}
+	}
+	result.Received = len(result.RTTs)
+
+	// only Unix pings number their replies, and they start at 0 on macOS and 1 on Linux
+	if len(seen) > 0 {
+		first := 1
+		if seen[0] {
+			first = 0
+		}
+		for seq := first; seq < first+count; seq++ {
+			if !seen[seq] {
+				result.LostSeqs = append(result.LostSeqs, seq)
+			}
+		}
 	}
 
-	// Run the command and capture output
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	cmd.Stderr = &out
-	err := cmd.Run()
 	if err != nil {
-		fmt.Println("Error running ping command:", err)
-		return -1, err
+		result.Error = err.Error()
+		return result, err
 	}
 
-	output := out.String()
-	return extractAvgRTT(output)
+	// fall back to the summary line when the per-reply lines were not recognised,
+	// in which case only the average and the loss are known
+	if result.Received == 0 {
+		avg, parseErr := extractAvgRTT(output)
+		if parseErr == nil {
+			m := pingLossPattern.FindStringSubmatch(output)
+			if m == nil {
+				parseErr = fmt.Errorf("could not parse ping loss")
+			} else {
+				loss, _ := strconv.ParseFloat(m[1], 64)
+				result.Received = count - int(math.Round(float64(count)*loss/100))
+			}
+		}
+		if parseErr != nil {
+			result.Error = parseErr.Error()
+			return result, parseErr
+		}
+		result.SummaryOnly, result.SummaryAvg = true, avg
+	}
+	return result, nil
 }
 
 // Extracts the average round-trip time (RTT) from ping output
@@ -46,7 +129,7 @@ func extractAvgRTT(output string) (float64, error) {
 	// Regex patterns for different OS outputs
 	patterns := []string{
 		`Average = (\d+)ms`, // Windows
-		`min/avg/max/stddev = [\d.]+/([\d.]+)/[\d.]+/(?:[\d.]+|nan) ms`, // Linux/macOS (handles 'nan')
+		`min/avg/max/(?:stddev|mdev) = [\d.]+/([\d.]+)/[\d.]+/(?:[\d.]+|nan) ms`, // Linux/macOS (handles 'nan')
 	}
 
 	for _, pattern := range patterns {
@@ -65,28 +148,32 @@ func extractAvgRTT(output string) (float64, error) {
 
 func Ping(server string, count int, timeout int) float64 {
 	fmt.Printf("%sPinging %s...\n", colorMap["green"], server)
-	ping, err := pingServer(server, count, timeout)
+	stats, err := pingServer(server, count, timeout)
 	if err != nil {
 		fmt.Println(colorMap["red"], "[Error] Error pinging server:", err)
 	}
-	var color string
-	if ping > 600 || ping == -1 {
-		color = colorMap["red"] + "❌ "
-	} else {
-		color = colorMap["grey"] + "✅ "
+	ping := stats.legacyAvg()
+	color := severityColor(pingSeverity(server, stats))
+	if ping == -1 && serviceReachable(server) {
+		color = colorMap["yellow"] + "⚠️  ICMP blocked, service reachable. "
 	}
-	fmt.Printf("%sAvg RTT: %.2f ms\n", color, ping)
-	recordPingResult(server, ping)
+	fmt.Printf("%sAvg RTT: %.2f ms, loss %.0f%% (%s)\n", color, ping, stats.LossPct, stats.Method)
+	recordPingResult(server, stats)
 	return ping
 }
 
-func recordPingResult(server string, ping float64) {
+// recordPingResult stores the structured stats and the legacy "%.2f ms" view the report server reads
+func recordPingResult(server string, stats PingStats) {
 	pingMu.Lock()
 	defer pingMu.Unlock()
 	if report.PingReports == nil {
 		report.PingReports = make(map[string]string)
 	}
-	report.PingReports[server] = fmt.Sprintf("%.2f ms", ping)
+	if report.PingStats == nil {
+		report.PingStats = make(map[string]PingStats)
+	}
+	report.PingReports[server] = fmt.Sprintf("%.2f ms", stats.legacyAvg())
+	report.PingStats[server] = stats
 }
 
 func hostAlreadyPinged(server string) bool {
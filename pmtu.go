@@ -0,0 +1,246 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	minPathMTU      = 576 // every IPv4 host must accept datagrams of this size
+	defaultMTU      = 1500
+	maxIPv4Packet   = 65535 // loopback MTUs are larger than any IPv4 packet
+	ipICMPHeaderLen = 28    // IPv4 header plus ICMP echo header
+	pmtuTimeout     = time.Second
+	pmtuAttempts    = 2
+)
+
+// errNoMTUReply means even the smallest probe got no answer, so nothing can be said about the MTU
+var errNoMTUReply = errors.New("no reply to the smallest probe")
+
+// PathMTU is the largest packet that reaches a host without fragmentation
+type PathMTU struct {
+	Host         string `json:"host"`
+	Method       string `json:"method"`
+	PathMTU      int    `json:"path_mtu,omitempty"`
+	Interface    string `json:"interface,omitempty"`
+	InterfaceMTU int    `json:"interface_mtu,omitempty"`
+	Mismatch     bool   `json:"mismatch"` // the path carries less than the interface sends
+	Error        string `json:"error,omitempty"`
+}
+
+// searchPathMTU binary searches the largest size in [low, high] that fits reports true for.
+// The upper bound is tried first since most paths carry the full interface MTU.
+func searchPathMTU(low, high int, fits func(size int) (bool, error)) (int, error) {
+	ok, err := fits(high)
+	if err != nil || ok {
+		return high, err
+	}
+	if ok, err = fits(low); err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, errNoMTUReply
+	}
+	for high-low > 1 {
+		mid := (low + high) / 2
+		ok, err := fits(mid)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			low = mid
+		} else {
+			high = mid
+		}
+	}
+	return low, nil
+}
+
+// outgoingInterface finds the interface the kernel would use to reach target.
+// Connecting a UDP socket only selects a route, nothing is sent.
+func outgoingInterface(target net.IP) (net.Interface, error) {
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: target, Port: 53})
+	if err != nil {
+		return net.Interface{}, err
+	}
+	local := conn.LocalAddr().(*net.UDPAddr).IP
+	conn.Close()
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return net.Interface{}, err
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(local) {
+				return iface, nil
+			}
+		}
+	}
+	return net.Interface{}, fmt.Errorf("no interface has address %s", local)
+}
+
+// probePathMTU discovers the path MTU to host with DF-set echo requests, using the native
+// ICMP engine when it can and the ping binary otherwise, like probePing
+func probePathMTU(host string) PathMTU {
+	result := PathMTU{Host: host}
+	target, err := resolveIPv4(host, pmtuTimeout)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	high := defaultMTU
+	if iface, err := outgoingInterface(target); err == nil && iface.MTU > 0 {
+		result.Interface, result.InterfaceMTU = iface.Name, iface.MTU
+		high = iface.MTU
+		if high > maxIPv4Packet {
+			high = maxIPv4Packet
+		}
+	}
+
+	var fits func(size int) (bool, error)
+	if os.Getenv("PING_ENGINE") != PingMethodBinary {
+		if sock, err := openICMPSocket(); err == nil {
+			defer sock.Close()
+			if err := setDontFragment(sock.conn); err == nil {
+				result.Method = sock.method
+				fits = nativeMTUProbe(sock, target)
+			}
+		}
+	}
+	if fits == nil {
+		result.Method = PingMethodBinary
+		fits = func(size int) (bool, error) { return binaryMTUProbe(target.String(), size), nil }
+	}
+
+	mtu, err := searchPathMTU(minPathMTU, high, fits)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.PathMTU = mtu
+	result.Mismatch = result.InterfaceMTU > 0 && mtu < high
+	return result
+}
+
+// nativeMTUProbe sends DF-set echo requests of the probed size on sock
+func nativeMTUProbe(sock *icmpSocket, target net.IP) func(size int) (bool, error) {
+	id := nextICMPID()
+	seq := 0
+	buf := make([]byte, 1<<16)
+	return func(size int) (bool, error) {
+		for attempt := 0; attempt < pmtuAttempts; attempt++ {
+			seq++
+			sent := time.Now()
+			if _, err := sock.send(buildSizedEchoRequest(id, uint16(seq), sent, size-ipICMPHeaderLen), target); err != nil {
+				// the kernel already knows a smaller MTU for this route
+				if errors.Is(err, syscall.EMSGSIZE) {
+					return false, nil
+				}
+				return false, err
+			}
+
+			deadline := sent.Add(pmtuTimeout)
+			for {
+				sock.conn.SetReadDeadline(deadline)
+				n, from, _, err := sock.readReply(buf)
+				if err != nil {
+					break
+				}
+				// a router on the way refused to fragment it
+				if probe, _, ok := matchICMPProbe(buf[:n], nil, target, id); ok && probe == seq && stripIPv4Header(buf[:n])[0] == icmpDestUnreachable {
+					return false, nil
+				}
+				if !sameIP(from, target) {
+					continue
+				}
+				replyID, replySeq, _, ok := parseEchoReply(buf[:n])
+				if ok && int(replySeq) == seq && (sock.method != PingMethodRaw || replyID == id) {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+	}
+}
+
+// binaryMTUProbe pings once with the Don't Fragment flag and the probed size
+func binaryMTUProbe(host string, size int) bool {
+	payload := strconv.Itoa(size - ipICMPHeaderLen)
+	var args []string
+	switch runtime.GOOS {
+	case "windows":
+		args = []string{"-f", "-l", payload, "-n", "1", "-w", "1000", host}
+	case "darwin":
+		args = []string{"-D", "-s", payload, "-c", "1", "-W", "1000", host}
+	default:
+		args = []string{"-M", "do", "-s", payload, "-c", "1", "-W", "1", host}
+	}
+	for attempt := 0; attempt < pmtuAttempts; attempt++ {
+		out, err := runCommand(5*time.Second, "ping", args...)
+		output := out.Output()
+		if err == nil && (pingReplyPattern.MatchString(output) || pingReplyWindowsPattern.MatchString(output)) {
+			return true
+		}
+	}
+	return false
+}
+
+var pmtuMu sync.Mutex
+
+// performPMTUProbes probes every host concurrently, stores the results in the report and
+// flags paths that carry less than the local interface
+func performPMTUProbes(hosts []string) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxHTTPConcurrency)
+
+	for _, raw := range unique(hosts) {
+		host := strings.TrimSpace(raw)
+		if net.ParseIP(host) == nil {
+			continue
+		}
+
+		wg.Add(1)
+		go func(h string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			result := probePathMTU(h)
+			pmtuMu.Lock()
+			if report.PathMTU == nil {
+				report.PathMTU = make(map[string]PathMTU)
+			}
+			report.PathMTU[h] = result
+			pmtuMu.Unlock()
+		}(host)
+	}
+	wg.Wait()
+
+	checkShecanMu.Lock()
+	defer checkShecanMu.Unlock()
+	for _, host := range unique(hosts) {
+		result, ok := report.PathMTU[strings.TrimSpace(host)]
+		if !ok || !result.Mismatch {
+			continue
+		}
+		fmt.Printf("%s [Warning] Path MTU to %s is %d, below the %d of %s; large DNS answers and TLS handshakes may stall\n",
+			colorMap["yellow"], result.Host, result.PathMTU, result.InterfaceMTU, result.Interface)
+		if check := report.CheckShecanResult[result.Host]; check.Error != "" {
+			fmt.Println(colorMap["yellow"], "[Warning] The MTU mismatch is a likely cause of the failed check over", result.Host)
+		}
+	}
+}
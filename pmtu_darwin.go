@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// ipDontFrag is IP_DONTFRAG from <netinet/in.h>, which the syscall package does not define
+const ipDontFrag = 28
+
+// setDontFragment sets DF on the packets sent on conn
+func setDontFragment(conn net.PacketConn) error {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return syscall.EINVAL
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, ipDontFrag, 1)
+	}); err != nil {
+		return err
+	}
+	return serr
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// setDontFragment turns on path MTU discovery for conn, so its packets carry DF and
+// oversized sends fail with EMSGSIZE instead of being fragmented
+func setDontFragment(conn net.PacketConn) error {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return syscall.EINVAL
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_DO)
+	}); err != nil {
+		return err
+	}
+	return serr
+}
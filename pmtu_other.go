@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// setDontFragment is not implemented here, the ping binary sets DF instead
+func setDontFragment(conn net.PacketConn) error {
+	return errors.New("don't fragment not supported on this platform")
+}
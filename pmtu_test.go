@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestSearchPathMTU(t *testing.T) {
+	for _, pathMTU := range []int{1500, 1492, 1420, 576} {
+		probes := 0
+		got, err := searchPathMTU(minPathMTU, 1500, func(size int) (bool, error) {
+			probes++
+			return size <= pathMTU, nil
+		})
+		if err != nil || got != pathMTU {
+			t.Errorf("path MTU %d: got %d, %v", pathMTU, got, err)
+		}
+		if probes > 12 {
+			t.Errorf("path MTU %d took %d probes", pathMTU, probes)
+		}
+	}
+
+	if _, err := searchPathMTU(minPathMTU, 1500, func(int) (bool, error) { return false, nil }); err != errNoMTUReply {
+		t.Errorf("silent host: err = %v", err)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reachability classifications combining ICMP and service probes
+const (
+	ReachabilityOK             = "reachable"
+	ReachabilityICMPBlocked    = "icmp_blocked_service_reachable"
+	ReachabilityServiceBlocked = "service_blocked"
+	ReachabilityServiceOnly    = "service_reachable" // not pinged, e.g. already reachable over HTTPS
+	ReachabilityUnreachable    = "unreachable"
+)
+
+// probeTCPPorts are connected to on every Shecan server: plain DNS, HTTPS and DNS over TLS
+var probeTCPPorts = []int{53, 443, 853}
+
+const portProbeTimeout = 2 * time.Second
+
+// PortProbe is the result of one TCP connect or UDP DNS query
+type PortProbe struct {
+	Proto     string  `json:"proto"`
+	Port      int     `json:"port"`
+	Open      bool    `json:"open"`
+	LatencyMs float64 `json:"latency_ms,omitempty"`
+	// probes test this machine's own path and connect directly even when HTTP goes through a proxy
+	BypassedProxy bool   `json:"bypassed_proxy,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// ServerReachability combines the port probes of one server with its ICMP result
+type ServerReachability struct {
+	Host           string      `json:"host"`
+	Probes         []PortProbe `json:"probes"`
+	ICMP           string      `json:"icmp"` // ok, failed or not_pinged
+	Classification string      `json:"classification"`
+}
+
+func probeTCP(host string, port int) PortProbe {
+	probe := PortProbe{Proto: "tcp", Port: port, BypassedProxy: proxyFor(net.JoinHostPort(host, strconv.Itoa(port))) != nil}
+	ctx, cancel := context.WithTimeout(context.Background(), portProbeTimeout)
+	defer cancel()
+
+	start := time.Now()
+	conn, err := dialerFor(portProbeTimeout)(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		probe.Error = err.Error()
+		return probe
+	}
+	probe.LatencyMs = round2(float64(time.Since(start).Microseconds()) / 1000)
+	probe.Open = true
+	conn.Close()
+	return probe
+}
+
+// buildDNSQuery encodes a recursive A query for name
+func buildDNSQuery(id uint16, name string) []byte {
+	msg := make([]byte, 12, 64)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[2:], 0x0100) // RD
+	binary.BigEndian.PutUint16(msg[4:], 1)      // QDCOUNT
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0, 0, 1, 0, 1) // root, QTYPE A, QCLASS IN
+	return msg
+}
+
+// probeUDPDNS sends a DNS query to host:53 and waits for a matching response
+func probeUDPDNS(host string) PortProbe {
+	probe := PortProbe{Proto: "udp", Port: 53, BypassedProxy: proxyFor(net.JoinHostPort(host, "53")) != nil}
+	ctx, cancel := context.WithTimeout(context.Background(), portProbeTimeout)
+	defer cancel()
+
+	conn, err := dialerFor(portProbeTimeout)(ctx, "udp", net.JoinHostPort(host, "53"))
+	if err != nil {
+		probe.Error = err.Error()
+		return probe
+	}
+	defer conn.Close()
+
+	id := uint16(rand.Intn(1 << 16))
+	start := time.Now()
+	conn.SetDeadline(start.Add(portProbeTimeout))
+	if _, err := conn.Write(buildDNSQuery(id, "check.shecan.ir")); err != nil {
+		probe.Error = err.Error()
+		return probe
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			probe.Error = err.Error()
+			return probe
+		}
+		// any response to our ID, even NXDOMAIN or REFUSED, proves the DNS service answers
+		if n >= 12 && binary.BigEndian.Uint16(buf[0:]) == id && buf[2]&0x80 != 0 {
+			probe.LatencyMs = round2(float64(time.Since(start).Microseconds()) / 1000)
+			probe.Open = true
+			return probe
+		}
+	}
+}
+
+func probeServer(host string) ServerReachability {
+	result := ServerReachability{Host: host}
+	for _, port := range probeTCPPorts {
+		result.Probes = append(result.Probes, probeTCP(host, port))
+	}
+	result.Probes = append(result.Probes, probeUDPDNS(host))
+	return result
+}
+
+var portProbeMu sync.Mutex
+
+// performPortProbes probes every host concurrently and stores the results in the report
+func performPortProbes(hosts []string) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxHTTPConcurrency)
+
+	for _, raw := range unique(hosts) {
+		host := strings.TrimSpace(raw)
+		if net.ParseIP(host) == nil {
+			continue
+		}
+
+		wg.Add(1)
+		go func(h string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			result := probeServer(h)
+			portProbeMu.Lock()
+			if report.Reachability == nil {
+				report.Reachability = make(map[string]ServerReachability)
+			}
+			report.Reachability[h] = result
+			portProbeMu.Unlock()
+		}(host)
+	}
+
+	wg.Wait()
+}
+
+// serviceOpen reports whether any port probe succeeded
+func (s ServerReachability) serviceOpen() bool {
+	for _, probe := range s.Probes {
+		if probe.Open {
+			return true
+		}
+	}
+	return false
+}
+
+// serviceReachable reports whether any port probe to host succeeded
+func serviceReachable(host string) bool {
+	portProbeMu.Lock()
+	defer portProbeMu.Unlock()
+	return report.Reachability[host].serviceOpen()
+}
+
+// classifyReachability combines port probes with ping results once both have run. It
+// runs after every round of pings, so it only reports hosts whose classification changed.
+func classifyReachability() {
+	pingMu.Lock()
+	stats := report.PingStats
+	pingMu.Unlock()
+
+	portProbeMu.Lock()
+	defer portProbeMu.Unlock()
+	for _, host := range sortedKeys(report.Reachability) {
+		result := report.Reachability[host]
+		service := result.serviceOpen()
+
+		ping, pinged := stats[host]
+		switch {
+		case !pinged:
+			result.ICMP = "not_pinged"
+		case ping.Received > 0:
+			result.ICMP = "ok"
+		default:
+			result.ICMP = "failed"
+		}
+
+		class, message := ReachabilityUnreachable, colorMap["red"]+" [Error] "+host+" is unreachable"
+		switch {
+		case result.ICMP == "ok" && service:
+			class, message = ReachabilityOK, ""
+		case result.ICMP == "failed" && service:
+			class, message = ReachabilityICMPBlocked, colorMap["yellow"]+" [Warning] "+host+" ICMP blocked but service reachable"
+		case result.ICMP == "ok":
+			class, message = ReachabilityServiceBlocked, colorMap["red"]+" [Error] "+host+" answers ping but no service port is reachable"
+		case service:
+			class, message = ReachabilityServiceOnly, ""
+		}
+		if class != result.Classification && message != "" {
+			fmt.Println(message)
+		}
+		result.Classification = class
+		report.Reachability[host] = result
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBuildDNSQuery(t *testing.T) {
+	got := buildDNSQuery(0xbeef, "check.shecan.ir")
+	want := []byte{
+		0xbe, 0xef, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0,
+		5, 'c', 'h', 'e', 'c', 'k', 6, 's', 'h', 'e', 'c', 'a', 'n', 2, 'i', 'r', 0,
+		0, 1, 0, 1,
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("buildDNSQuery = %x", got)
+	}
+}
+
+func TestClassifyReachability(t *testing.T) {
+	open := []PortProbe{{Proto: "udp", Port: 53, Open: true}}
+	closed := []PortProbe{{Proto: "tcp", Port: 53}}
+	report = Report{
+		PingStats: map[string]PingStats{
+			"1.1.1.1": {Sent: 4, Received: 0},
+			"2.2.2.2": {Sent: 4, Received: 4},
+			"3.3.3.3": {Sent: 4, Received: 4},
+			"4.4.4.4": {Sent: 4, Received: 0},
+		},
+		Reachability: map[string]ServerReachability{
+			"1.1.1.1": {Host: "1.1.1.1", Probes: open},
+			"2.2.2.2": {Host: "2.2.2.2", Probes: open},
+			"3.3.3.3": {Host: "3.3.3.3", Probes: closed},
+			"4.4.4.4": {Host: "4.4.4.4", Probes: closed},
+			"5.5.5.5": {Host: "5.5.5.5", Probes: open},
+		},
+	}
+	t.Cleanup(func() { report = Report{} })
+
+	classifyReachability()
+
+	want := map[string]string{
+		"1.1.1.1": ReachabilityICMPBlocked,
+		"2.2.2.2": ReachabilityOK,
+		"3.3.3.3": ReachabilityServiceBlocked,
+		"4.4.4.4": ReachabilityUnreachable,
+		"5.5.5.5": ReachabilityServiceOnly,
+	}
+	for host, class := range want {
+		if got := report.Reachability[host].Classification; got != class {
+			t.Errorf("%s classified as %s, want %s", host, got, class)
+		}
+	}
+}
+
+func TestClassifyReachabilityBeforeIPChecks(t *testing.T) {
+	open := []PortProbe{{Proto: "udp", Port: 53, Open: true}}
+	// only the plan DNS servers have been probed and pinged, as when the run stops at the DNS leak gate
+	report = Report{
+		PingStats:    map[string]PingStats{"178.22.122.100": {Sent: 4}},
+		Reachability: map[string]ServerReachability{"178.22.122.100": {Host: "178.22.122.100", Probes: open}},
+	}
+	t.Cleanup(func() { report = Report{} })
+
+	classifyReachability()
+	r := report
+	if findings := diagnose(&r); len(findings) != 1 || findings[0].ID != "icmp-blocked" {
+		t.Errorf("findings = %+v", findings)
+	}
+
+	// the Shecan IPs are classified in a second round without touching the DNS servers
+	report.PingStats["10.0.0.1"] = PingStats{Sent: 2, Received: 2}
+	report.Reachability["10.0.0.1"] = ServerReachability{Host: "10.0.0.1", Probes: open}
+	classifyReachability()
+	if report.Reachability["178.22.122.100"].Classification != ReachabilityICMPBlocked || report.Reachability["10.0.0.1"].Classification != ReachabilityOK {
+		t.Errorf("reachability = %+v", report.Reachability)
+	}
+}
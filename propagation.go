@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Propagation statuses recorded in the report
+const (
+	PropagationDone      = "propagated"
+	PropagationTimeout   = "timeout"
+	PropagationCancelled = "cancelled"
+	PropagationError     = "error"
+)
+
+// PropagationResult records how long check.shecan.ir took to accept our IP after the updater call
+type PropagationResult struct {
+	URL           string  `json:"url"`
+	Status        string  `json:"status"`
+	Checks        int     `json:"checks"`
+	LastCode      int     `json:"last_code,omitempty"`
+	WaitedSeconds float64 `json:"waited_seconds"`
+	Error         string  `json:"error,omitempty"`
+}
+
+// waitForPropagation polls url every interval while it answers 403, up to maxWait.
+// The first request failing is reported as an error; later failures keep the loop going.
+func waitForPropagation(ctx context.Context, url string, interval, maxWait time.Duration) PropagationResult {
+	start := time.Now()
+	deadline := start.Add(maxWait)
+	result := PropagationResult{URL: url}
+	waited := false
+
+	for {
+		result.Checks++
+		response, err := HTTPRequestWithContext(ctx, url, "GET", "", "", "2")
+		if err != nil {
+			result.Error = err.Error()
+			if ctx.Err() != nil {
+				result.Status = PropagationCancelled
+				break
+			}
+			if result.Checks == 1 {
+				result.Status = PropagationError
+				break
+			}
+		} else {
+			result.LastCode = response.StatusCode
+			result.Error = ""
+			discardResponse(response)
+			if response.StatusCode != http.StatusForbidden {
+				result.Status = PropagationDone
+				break
+			}
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			result.Status = PropagationTimeout
+			break
+		}
+		wait := interval
+		if wait > remaining {
+			wait = remaining
+		}
+		waited = true
+		if err := countdown(ctx, wait, deadline); err != nil {
+			result.Status = PropagationCancelled
+			break
+		}
+	}
+
+	if waited {
+		fmt.Println()
+	}
+	result.WaitedSeconds = time.Since(start).Round(time.Millisecond).Seconds()
+	return result
+}
+
+// countdown waits for d, redrawing the time left until deadline once a second
+func countdown(ctx context.Context, d time.Duration, deadline time.Time) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	for {
+		left := time.Until(deadline).Round(time.Second)
+		fmt.Printf("\r%s [Waiting] check.shecan.ir not ready yet, giving up in %s (Ctrl+C to skip)   %s", colorMap["yellow"], left, colorMap["reset"])
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
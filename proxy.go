@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Proxy policies selected with --proxy / --no-proxy
+const (
+	ProxyPolicyEnvironment = "environment" // honour HTTP(S)_PROXY and NO_PROXY
+	ProxyPolicyExplicit    = "explicit"    // send everything through --proxy
+	ProxyPolicyDisabled    = "disabled"    // connect directly
+)
+
+// ProxySetting is one proxy configuration found on the system
+type ProxySetting struct {
+	Source string `json:"source"` // env, gnome, kde or macos
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+}
+
+// ProxyInfo records the proxies found on the system and the one the diagnostic used
+type ProxyInfo struct {
+	Policy   string         `json:"policy"`
+	Active   string         `json:"active,omitempty"`
+	Detected []ProxySetting `json:"detected,omitempty"`
+	PACURLs  []string       `json:"pac_urls,omitempty"`
+	Warnings []string       `json:"warnings,omitempty"`
+}
+
+var (
+	proxyPolicy = ProxyPolicyEnvironment
+	proxyURL    *url.URL
+)
+
+// configureProxy applies the --proxy / --no-proxy flags to every HTTP client
+func configureProxy(explicit string, disabled bool) error {
+	switch {
+	case disabled:
+		proxyPolicy, proxyURL = ProxyPolicyDisabled, nil
+	case explicit != "":
+		u, err := url.Parse(explicit)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("invalid proxy URL %q", explicit)
+		}
+		proxyPolicy, proxyURL = ProxyPolicyExplicit, u
+	default:
+		proxyPolicy, proxyURL = ProxyPolicyEnvironment, nil
+	}
+	return nil
+}
+
+// proxyForRequest implements http.Transport.Proxy for the selected policy
+func proxyForRequest(req *http.Request) (*url.URL, error) {
+	switch proxyPolicy {
+	case ProxyPolicyDisabled:
+		return nil, nil
+	case ProxyPolicyExplicit:
+		return proxyURL, nil
+	default:
+		return http.ProxyFromEnvironment(req)
+	}
+}
+
+var proxyEnvKeys = []string{"HTTP_PROXY", "HTTPS_PROXY", "ALL_PROXY", "NO_PROXY", "http_proxy", "https_proxy", "all_proxy", "no_proxy"}
+
+func detectEnvProxies() []ProxySetting {
+	var found []ProxySetting
+	for _, key := range proxyEnvKeys {
+		if value := os.Getenv(key); value != "" {
+			found = append(found, ProxySetting{Source: "env", Name: key, Value: value})
+		}
+	}
+	return found
+}
+
+// detectGnomeProxy reads org.gnome.system.proxy through gsettings
+func detectGnomeProxy() ([]ProxySetting, []string) {
+	get := func(schema, key string) string {
+		result, err := runCommand(2*time.Second, "gsettings", "get", schema, key)
+		if err != nil {
+			return ""
+		}
+		return strings.Trim(strings.TrimSpace(result.Stdout), "'")
+	}
+
+	switch get("org.gnome.system.proxy", "mode") {
+	case "manual":
+		var found []ProxySetting
+		for _, scheme := range []string{"http", "https", "socks"} {
+			schema := "org.gnome.system.proxy." + scheme
+			host, port := get(schema, "host"), get(schema, "port")
+			if host != "" && port != "" && port != "0" {
+				found = append(found, ProxySetting{Source: "gnome", Name: scheme, Value: host + ":" + port})
+			}
+		}
+		return found, nil
+	case "auto":
+		if pac := get("org.gnome.system.proxy", "autoconfig-url"); pac != "" {
+			return nil, []string{pac}
+		}
+		return []ProxySetting{{Source: "gnome", Name: "mode", Value: "auto (WPAD)"}}, nil
+	}
+	return nil, nil
+}
+
+// detectKDEProxy parses the [Proxy Settings] group of ~/.config/kioslaverc
+func detectKDEProxy() ([]ProxySetting, []string) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, nil
+	}
+	file, err := os.Open(filepath.Join(home, ".config", "kioslaverc"))
+	if err != nil {
+		return nil, nil
+	}
+	defer file.Close()
+
+	settings := map[string]string{}
+	inProxyGroup := false
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			inProxyGroup = line == "[Proxy Settings]"
+			continue
+		}
+		if key, value, ok := strings.Cut(line, "="); ok && inProxyGroup {
+			settings[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+
+	// ProxyType: 0 none, 1 manual, 2 PAC, 3 WPAD, 4 environment
+	switch settings["ProxyType"] {
+	case "1":
+		var found []ProxySetting
+		for _, key := range []string{"httpProxy", "httpsProxy", "socksProxy"} {
+			if value := strings.ReplaceAll(settings[key], " ", ":"); value != "" {
+				found = append(found, ProxySetting{Source: "kde", Name: key, Value: value})
+			}
+		}
+		return found, nil
+	case "2":
+		if pac := settings["Proxy Config Script"]; pac != "" {
+			return nil, []string{pac}
+		}
+	case "3":
+		return []ProxySetting{{Source: "kde", Name: "ProxyType", Value: "WPAD"}}, nil
+	}
+	return nil, nil
+}
+
+// detectMacProxy parses `scutil --proxy`
+func detectMacProxy() ([]ProxySetting, []string) {
+	result, err := runCommand(2*time.Second, "scutil", "--proxy")
+	if err != nil {
+		return nil, nil
+	}
+	values := map[string]string{}
+	for _, line := range strings.Split(result.Stdout, "\n") {
+		if key, value, ok := strings.Cut(line, " : "); ok {
+			values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+
+	var found []ProxySetting
+	for _, scheme := range []string{"HTTP", "HTTPS", "SOCKS"} {
+		if values[scheme+"Enable"] == "1" && values[scheme+"Proxy"] != "" {
+			found = append(found, ProxySetting{Source: "macos", Name: strings.ToLower(scheme), Value: values[scheme+"Proxy"] + ":" + values[scheme+"Port"]})
+		}
+	}
+	var pacs []string
+	if values["ProxyAutoConfigEnable"] == "1" && values["ProxyAutoConfigURLString"] != "" {
+		pacs = append(pacs, values["ProxyAutoConfigURLString"])
+	}
+	return found, pacs
+}
+
+// detectProxy gathers every proxy setting we know how to read and explains its effect
+func detectProxy() *ProxyInfo {
+	info := &ProxyInfo{Policy: proxyPolicy, Detected: detectEnvProxies()}
+
+	var system []ProxySetting
+	var pacs []string
+	switch runtime.GOOS {
+	case "linux":
+		gnome, gnomePAC := detectGnomeProxy()
+		kde, kdePAC := detectKDEProxy()
+		system = append(gnome, kde...)
+		pacs = append(gnomePAC, kdePAC...)
+	case "darwin":
+		system, pacs = detectMacProxy()
+	}
+	info.Detected = append(info.Detected, system...)
+	info.PACURLs = pacs
+
+	if req, err := http.NewRequest("GET", endpoints.Check, nil); err == nil {
+		if u, err := proxyForRequest(req); err == nil && u != nil {
+			info.Active = u.Redacted()
+		}
+	}
+
+	if info.Active != "" {
+		info.Warnings = append(info.Warnings, fmt.Sprintf("requests go through proxy %s, so results reflect the proxy's DNS rather than Shecan", info.Active))
+	}
+	if len(system) > 0 || len(pacs) > 0 {
+		info.Warnings = append(info.Warnings, "a system proxy is configured; browsers using it may bypass Shecan even when this check passes")
+	}
+	return info
+}
+
+func printProxyInfo(info *ProxyInfo) {
+	for _, setting := range info.Detected {
+		fmt.Println(colorMap["blue"], "[INFO] Proxy found in", setting.Source+":", setting.Name, "=", setting.Value)
+	}
+	for _, pac := range info.PACURLs {
+		fmt.Println(colorMap["blue"], "[INFO] Proxy auto-config URL:", pac)
+	}
+	for _, warning := range info.Warnings {
+		fmt.Println(colorMap["yellow"], "[Warning]", warning)
+	}
+}
+
+// proxyFor returns the proxy the selected policy uses for an HTTPS connection to addr, or nil
+func proxyFor(addr string) *url.URL {
+	proxy, err := proxyForRequest(&http.Request{URL: &url.URL{Scheme: "https", Host: addr}})
+	if err != nil {
+		return nil
+	}
+	return proxy
+}
+
+// dialThroughProxy opens a TCP stream to addr the way HTTP requests reach it: through the
+// proxy in force with CONNECT (or SOCKS5), directly otherwise. It returns the proxy used.
+func dialThroughProxy(ctx context.Context, timeout time.Duration, addr string) (net.Conn, *url.URL, error) {
+	proxy := proxyFor(addr)
+	if proxy == nil {
+		conn, err := dialerFor(timeout)(ctx, "tcp", addr)
+		return conn, nil, err
+	}
+
+	proxyAddr := proxy.Host
+	if proxy.Port() == "" {
+		port := "80"
+		switch proxy.Scheme {
+		case "https":
+			port = "443"
+		case "socks5", "socks5h":
+			port = "1080"
+		}
+		proxyAddr = net.JoinHostPort(proxy.Hostname(), port)
+	}
+	conn, err := dialerFor(timeout)(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, proxy, fmt.Errorf("proxy %s: %w", proxy.Redacted(), err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	var tunnel net.Conn
+	switch proxy.Scheme {
+	case "socks5", "socks5h":
+		err = socks5Connect(conn, proxy, addr)
+		tunnel = conn
+	case "https":
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxy.Hostname(), RootCAs: tlsRootCAs})
+		conn = tlsConn
+		if err = tlsConn.HandshakeContext(ctx); err == nil {
+			tunnel, err = httpConnect(tlsConn, proxy, addr)
+		}
+	case "http", "":
+		tunnel, err = httpConnect(conn, proxy, addr)
+	default:
+		err = fmt.Errorf("unsupported proxy scheme %q", proxy.Scheme)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, proxy, fmt.Errorf("proxy %s: %w", proxy.Redacted(), err)
+	}
+	return tunnel, proxy, nil
+}
+
+// bufferedConn keeps bytes the proxy sent right after its CONNECT answer
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// httpConnect asks an HTTP proxy for a tunnel to addr
+func httpConnect(conn net.Conn, proxy *url.URL, addr string) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxy.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("CONNECT %s: %s", addr, resp.Status)
+	}
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// socks5Connect asks a SOCKS5 proxy (RFC 1928) for a connection to addr, with
+// username/password authentication (RFC 1929) when the proxy URL carries credentials
+func socks5Connect(conn net.Conn, proxy *url.URL, addr string) error {
+	host, portString, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portString)
+	if err != nil {
+		return err
+	}
+
+	method := byte(0x00) // no authentication
+	if proxy.User != nil {
+		method = 0x02
+	}
+	if _, err := conn.Write([]byte{0x05, 1, method}); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 0x05 || reply[1] != method {
+		return errors.New("SOCKS5 authentication method rejected")
+	}
+	if method == 0x02 {
+		password, _ := proxy.User.Password()
+		user := proxy.User.Username()
+		auth := append([]byte{0x01, byte(len(user))}, user...)
+		auth = append(append(auth, byte(len(password))), password...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0x00 {
+			return errors.New("SOCKS5 authentication failed")
+		}
+	}
+
+	request := []byte{0x05, 0x01, 0x00}
+	if ip := net.ParseIP(host); ip.To4() != nil {
+		request = append(append(request, 0x01), ip.To4()...)
+	} else if ip != nil {
+		request = append(append(request, 0x04), ip.To16()...)
+	} else {
+		request = append(append(request, 0x03, byte(len(host))), host...)
+	}
+	request = binary.BigEndian.AppendUint16(request, uint16(port))
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != 0x00 {
+		return fmt.Errorf("SOCKS5 connect failed with code %d", header[1])
+	}
+	// skip the bound address
+	skip := 0
+	switch header[3] {
+	case 0x01:
+		skip = net.IPv4len
+	case 0x04:
+		skip = net.IPv6len
+	case 0x03:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return err
+		}
+		skip = int(length[0])
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
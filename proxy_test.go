@@ -0,0 +1,164 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestProxyPolicy(t *testing.T) {
+	t.Cleanup(func() { configureProxy("", false) })
+	req, _ := http.NewRequest("GET", "https://check.shecan.ir", nil)
+
+	if err := configureProxy("http://127.0.0.1:8080", false); err != nil {
+		t.Fatal(err)
+	}
+	if u, _ := proxyForRequest(req); u == nil || u.Host != "127.0.0.1:8080" {
+		t.Errorf("explicit proxy = %v", u)
+	}
+
+	if err := configureProxy("", true); err != nil {
+		t.Fatal(err)
+	}
+	if u, _ := proxyForRequest(req); u != nil {
+		t.Errorf("disabled policy still proxies via %v", u)
+	}
+
+	if err := configureProxy("not a url", false); err == nil {
+		t.Error("expected an invalid proxy URL to be rejected")
+	}
+}
+
+func TestDetectKDEProxy(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	if err := os.MkdirAll(filepath.Join(home, ".config"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	rc := "[General]\nProxyType=0\n\n[Proxy Settings]\nProxyType=1\nhttpProxy=http://10.0.0.5 3128\nhttpsProxy=http://10.0.0.5 3128\n"
+	if err := os.WriteFile(filepath.Join(home, ".config", "kioslaverc"), []byte(rc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	found, pacs := detectKDEProxy()
+	if len(found) != 2 || found[0].Value != "http://10.0.0.5:3128" || len(pacs) != 0 {
+		t.Errorf("detectKDEProxy = %+v, %v", found, pacs)
+	}
+
+	rc = "[Proxy Settings]\nProxyType=2\nProxy Config Script=http://wpad.lan/proxy.pac\n"
+	if err := os.WriteFile(filepath.Join(home, ".config", "kioslaverc"), []byte(rc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, pacs := detectKDEProxy(); len(pacs) != 1 || pacs[0] != "http://wpad.lan/proxy.pac" {
+		t.Errorf("PAC = %v", pacs)
+	}
+}
+
+// connectProxy is an HTTP proxy that only tunnels CONNECT requests, all of them to
+// upstream, and counts them
+func connectProxy(t *testing.T, upstream string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var tunnels atomic.Int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
+			return
+		}
+		conn, err := net.Dial("tcp", upstream)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		tunnels.Add(1)
+		client, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			conn.Close()
+			return
+		}
+		client.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		go func() { io.Copy(conn, client); conn.Close() }()
+		io.Copy(client, conn)
+		client.Close()
+	}))
+	t.Cleanup(proxy.Close)
+	return proxy, &tunnels
+}
+
+// socks5Proxy accepts one SOCKS5 connection without authentication and relays it to upstream
+func socks5Proxy(t *testing.T, upstream string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		client, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer client.Close()
+		greeting := make([]byte, 3)
+		io.ReadFull(client, greeting)
+		client.Write([]byte{0x05, 0x00})
+		request := make([]byte, 4+net.IPv4len+2) // an IPv4 CONNECT
+		io.ReadFull(client, request)
+		conn, err := net.Dial("tcp", upstream)
+		if err != nil {
+			client.Write([]byte{0x05, 0x05, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		defer conn.Close()
+		client.Write([]byte{0x05, 0x00, 0x00, 0x01, 127, 0, 0, 1, 0, 0})
+		go io.Copy(conn, client)
+		io.Copy(client, conn)
+	}()
+	return ln.Addr().String()
+}
+
+func TestInspectTLSThroughProxy(t *testing.T) {
+	t.Cleanup(func() { configureProxy("", false) })
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	proxy, tunnels := connectProxy(t, server.Listener.Addr().String())
+
+	if err := configureProxy(proxy.URL, false); err != nil {
+		t.Fatal(err)
+	}
+	// inspectTLS connects to port 443, which only exists behind the proxy
+	inspection := inspectTLS("127.0.0.1", "example.com", 2*time.Second)
+	if inspection.Proxy != proxy.URL || len(inspection.Chain) == 0 || tunnels.Load() != 1 {
+		t.Errorf("inspection = %+v, %d tunnels", inspection, tunnels.Load())
+	}
+
+	if err := configureProxy("socks5://"+socks5Proxy(t, server.Listener.Addr().String()), false); err != nil {
+		t.Fatal(err)
+	}
+	inspection = inspectTLS("127.0.0.1", "example.com", 2*time.Second)
+	if !strings.HasPrefix(inspection.Proxy, "socks5://") || len(inspection.Chain) == 0 {
+		t.Errorf("SOCKS5 inspection = %+v", inspection)
+	}
+}
+
+func TestPortProbesRecordProxyBypass(t *testing.T) {
+	t.Cleanup(func() { configureProxy("", false) })
+	if err := configureProxy("", true); err != nil {
+		t.Fatal(err)
+	}
+	if probe := probeTCP("127.0.0.1", 1); probe.BypassedProxy {
+		t.Errorf("probe without a proxy marked as bypassing one: %+v", probe)
+	}
+	if err := configureProxy("http://127.0.0.1:3128", false); err != nil {
+		t.Fatal(err)
+	}
+	if probe := probeTCP("127.0.0.1", 1); !probe.BypassedProxy {
+		t.Errorf("direct probe under a proxy not marked: %+v", probe)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PublicIPObservation is the address one source saw us coming from over one address family
+type PublicIPObservation struct {
+	Source    string  `json:"source"`
+	Family    string  `json:"family"` // ipv4 or ipv6
+	IP        string  `json:"ip,omitempty"`
+	LatencyMs float64 `json:"latency_ms,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// PublicIPInfo combines every observation. Sources disagreeing within a family points to
+// split routing or a VPN that only carries part of the traffic.
+type PublicIPInfo struct {
+	IPv4         string                `json:"ipv4,omitempty"`
+	IPv6         string                `json:"ipv6,omitempty"`
+	Observations []PublicIPObservation `json:"observations"`
+	Disagreement bool                  `json:"disagreement"`
+	DDNSIP       string                `json:"ddns_ip,omitempty"`    // address the updater registered
+	DDNSMatch    *bool                 `json:"ddns_match,omitempty"` // whether Shecan sees us from DDNSIP
+}
+
+// publicIPSources returns PUBLIC_IP_SOURCES, or Shecan's echo followed by two public ones.
+// Shecan comes first so it wins ties and is the one compared with the DDNS address.
+func publicIPSources() []string {
+	if sources := envList("PUBLIC_IP_SOURCES"); len(sources) > 0 {
+		return sources
+	}
+	return []string{endpoints.Shecan + "/ip/", "https://icanhazip.com/", "https://ifconfig.me/ip"}
+}
+
+// familyClient is an HTTP client whose connections only use network, "tcp4" or "tcp6"
+func familyClient(timeout time.Duration, network string) *http.Client {
+	dial := dialerFor(timeout)
+	transport := defaultTransport(timeout, "").(*http.Transport)
+	transport.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+		return dial(ctx, network, addr)
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+func observePublicIP(client *http.Client, source, family string) PublicIPObservation {
+	obs := PublicIPObservation{Source: source, Family: family}
+	start := time.Now()
+	resp, err := client.Get(source)
+	if err != nil {
+		obs.Error = err.Error()
+		return obs
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+	if err != nil {
+		obs.Error = err.Error()
+		return obs
+	}
+	if resp.StatusCode != http.StatusOK {
+		obs.Error = fmt.Sprintf("status %d", resp.StatusCode)
+		return obs
+	}
+
+	ip := net.ParseIP(strings.TrimSpace(string(body)))
+	if ip == nil || (ip.To4() != nil) != (family == "ipv4") {
+		obs.Error = fmt.Sprintf("unexpected answer %q", strings.TrimSpace(string(body)))
+		return obs
+	}
+	obs.IP = ip.String()
+	obs.LatencyMs = round2(float64(time.Since(start).Microseconds()) / 1000)
+	return obs
+}
+
+// detectPublicIPs asks every source over IPv4 and IPv6 at once, waiting PUBLIC_IP_TIMEOUT at most
+func detectPublicIPs() *PublicIPInfo {
+	timeout := envDuration("PUBLIC_IP_TIMEOUT", 5*time.Second)
+	sources := publicIPSources()
+	families := []struct{ name, network string }{{"ipv4", "tcp4"}, {"ipv6", "tcp6"}}
+
+	observations := make([]PublicIPObservation, len(sources)*len(families))
+	var wg sync.WaitGroup
+	for f, family := range families {
+		client := familyClient(timeout, family.network)
+		for s, source := range sources {
+			wg.Add(1)
+			go func(i int, source, family string) {
+				defer wg.Done()
+				observations[i] = observePublicIP(client, source, family)
+			}(f*len(sources)+s, source, family.name)
+		}
+	}
+	wg.Wait()
+
+	info := &PublicIPInfo{Observations: observations}
+	var ipv4Disagree, ipv6Disagree bool
+	info.IPv4, ipv4Disagree = consensusIP(observations, "ipv4")
+	info.IPv6, ipv6Disagree = consensusIP(observations, "ipv6")
+	info.Disagreement = ipv4Disagree || ipv6Disagree
+	return info
+}
+
+// consensusIP picks the address most sources of family agree on, the earliest source
+// breaking ties, and reports whether any source saw a different one
+func consensusIP(observations []PublicIPObservation, family string) (string, bool) {
+	counts := map[string]int{}
+	var order []string
+	for _, obs := range observations {
+		if obs.Family != family || obs.IP == "" {
+			continue
+		}
+		if counts[obs.IP] == 0 {
+			order = append(order, obs.IP)
+		}
+		counts[obs.IP]++
+	}
+	best := ""
+	for _, ip := range order {
+		if counts[ip] > counts[best] {
+			best = ip
+		}
+	}
+	return best, len(order) > 1
+}
+
+// primary is the address kept in the report's legacy public_ip field
+func (p *PublicIPInfo) primary() string {
+	if p.IPv4 != "" {
+		return p.IPv4
+	}
+	return p.IPv6
+}
+
+// shecanView returns the address Shecan's own echo saw for family
+func (p *PublicIPInfo) shecanView(family string) string {
+	for _, obs := range p.Observations {
+		if obs.Family == family && obs.IP != "" && strings.HasPrefix(obs.Source, endpoints.Shecan+"/") {
+			return obs.IP
+		}
+	}
+	return ""
+}
+
+var updaterIPPattern = regexp.MustCompile(`[0-9a-fA-F:.]{7,}`)
+
+// recordDDNSAddress compares the address the updater answered with, if any, to the
+// one Shecan sees us coming from
+func (p *PublicIPInfo) recordDDNSAddress(updaterResponse string) {
+	for _, candidate := range updaterIPPattern.FindAllString(updaterResponse, -1) {
+		ip := net.ParseIP(candidate)
+		if ip == nil {
+			continue
+		}
+		family := "ipv6"
+		if ip.To4() != nil {
+			family = "ipv4"
+		}
+		p.DDNSIP = ip.String()
+		if seen := p.shecanView(family); seen != "" {
+			match := seen == p.DDNSIP
+			p.DDNSMatch = &match
+		}
+		return
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func echoServer(t *testing.T, answer string) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, answer)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL + "/ip/"
+}
+
+func TestDetectPublicIPs(t *testing.T) {
+	agree, other, broken := echoServer(t, "192.0.2.10"), echoServer(t, "198.51.100.7"), echoServer(t, "<html>")
+	for name, tc := range map[string]struct {
+		sources  string
+		ipv4     string
+		disagree bool
+	}{
+		"agree":    {agree + "," + agree + "," + broken, "192.0.2.10", false},
+		"majority": {other + "," + agree + "," + agree, "192.0.2.10", true},
+		"tie":      {other + "," + agree, "198.51.100.7", true},
+	} {
+		t.Setenv("PUBLIC_IP_SOURCES", tc.sources)
+		info := detectPublicIPs()
+		if info.IPv4 != tc.ipv4 || info.Disagreement != tc.disagree || info.IPv6 != "" {
+			t.Errorf("%s: %+v", name, info)
+		}
+		// every source is asked over both families
+		if len(info.Observations) != 2*len(envList("PUBLIC_IP_SOURCES")) {
+			t.Errorf("%s: %d observations", name, len(info.Observations))
+		}
+	}
+}
+
+func TestRecordDDNSAddress(t *testing.T) {
+	info := &PublicIPInfo{Observations: []PublicIPObservation{
+		{Source: endpoints.Shecan + "/ip/", Family: "ipv4", IP: "192.0.2.10"},
+		{Source: "https://icanhazip.com/", Family: "ipv4", IP: "198.51.100.7"},
+	}}
+
+	info.recordDDNSAddress("OK")
+	if info.DDNSIP != "" || info.DDNSMatch != nil {
+		t.Errorf("no address in the answer: %+v", info)
+	}
+	info.recordDDNSAddress("OK 192.0.2.10")
+	if info.DDNSMatch == nil || !*info.DDNSMatch {
+		t.Errorf("same address: %+v", info)
+	}
+	info.recordDDNSAddress("good 198.51.100.7")
+	if info.DDNSMatch == nil || *info.DDNSMatch {
+		t.Errorf("address only another source sees: %+v", info)
+	}
+}
@@ -6,37 +6,62 @@ import (
 	"fmt"
 	"io"
 	"net"
-	"net/http"
 	"os"
-	"os/exec"
+	"regexp"
 	"runtime"
 	"strings"
+	"time"
 )
 
 type CheckShecan struct {
-	Code   int    `json:"code"`
-	Result string `json:"result"`
-	Error  string `json:"error"`
+	Code   int            `json:"code"`
+	Result string         `json:"result"`
+	Error  string         `json:"error"`
+	TLS    *TLSInspection `json:"tls,omitempty"`
 }
 
 // Report struct to hold the system information
 type Report struct {
-	Hostname          string                 `json:"hostname"`
-	OS                string                 `json:"os"`
-	IPs               []string               `json:"local_ips"`
-	PublicIP          string                 `json:"public_ip"`
-	Plan              Plan                   `json:"plan"`
-	PingReports       map[string]string      `json:"ping_reports"`
-	LocalTime         string                 `json:"local_time"`
-	RealTime          string                 `json:"real_time"`
-	CPUInfo           string                 `json:"cpu"`
-	MemoryInfo        string                 `json:"memory"`
-	DiskInfo          string                 `json:"disk"`
-	DNSServers        []string               `json:"dns_servers"`
-	RequestResult     map[string]string      `json:"request_result"`
-	NsLookup          map[string][]DNSRecord `json:"ns_lookup"`
-	CheckShecanResult map[string]CheckShecan `json:"check_shecan_result"`
-	UpdaterLink       string                 `json:"updater_link"`
+	Hostname              string                        `json:"hostname"`
+	OS                    string                        `json:"os"`
+	IPs                   []string                      `json:"local_ips"`
+	Network               *NetworkInventory             `json:"network,omitempty"`
+	VPN                   *VPNDetection                 `json:"vpn,omitempty"`
+	Hosts                 *HostsInspection              `json:"hosts,omitempty"`
+	PublicIP              string                        `json:"public_ip"`
+	PublicIPs             *PublicIPInfo                 `json:"public_ips,omitempty"`
+	IPAnnotations         map[string]IPAnnotation       `json:"ip_annotations,omitempty"`
+	Plan                  Plan                          `json:"plan"`
+	PingReports           map[string]string             `json:"ping_reports"`
+	PingStats             map[string]PingStats          `json:"ping_stats,omitempty"`
+	Reachability          map[string]ServerReachability `json:"reachability,omitempty"`
+	PathMTU               map[string]PathMTU            `json:"path_mtu,omitempty"`
+	LocalTime             string                        `json:"local_time"`
+	RealTime              string                        `json:"real_time"`
+	Clock                 *ClockInfo                    `json:"clock,omitempty"`
+	CaptivePortal         *CaptivePortalResult          `json:"captive_portal,omitempty"`
+	CPUInfo               string                        `json:"cpu"`
+	MemoryInfo            string                        `json:"memory"`
+	DiskInfo              string                        `json:"disk"`
+	DNSServers            []string                      `json:"dns_servers"`
+	LocalDNS              *LocalDNS                     `json:"local_dns,omitempty"`
+	RequestResult         map[string]string             `json:"request_result"`
+	RequestClassification map[string]Classification     `json:"request_classification,omitempty"`
+	SignatureVersion      int                           `json:"signature_version,omitempty"`
+	NsLookup              map[string][]DNSRecord        `json:"ns_lookup"`
+	CheckShecanResult     map[string]CheckShecan        `json:"check_shecan_result"`
+	ServiceChecks         []ServiceCheck                `json:"service_checks,omitempty"`
+	UpdaterLink           string                        `json:"updater_link"`
+	UpdaterResponse       string                        `json:"updater_response,omitempty"`
+	ShecanDNS             []string                      `json:"shecan_dns,omitempty"`
+	Retries               []RetryAttempt                `json:"retries,omitempty"`
+	Propagation           *PropagationResult            `json:"propagation,omitempty"`
+	Commands              []CommandResult               `json:"commands,omitempty"`
+	Proxy                 *ProxyInfo                    `json:"proxy,omitempty"`
+	Challenges            []ChallengeEvent              `json:"challenges,omitempty"`
+	Traces                []TraceResult                 `json:"traces,omitempty"`
+	Health                []HealthCheck                 `json:"health,omitempty"`
+	Findings              []Finding                     `json:"findings,omitempty"`
 }
 
 // getLocalIPs retrieves all local IPs
@@ -65,36 +90,21 @@ func getLocalIPs() ([]string, error) {
 	return ips, nil
 }
 
-// getPublicIP retrieves the external IP from shecan.ir
-func getPublicIP() (string, error) {
-	resp, err := http.Get("https://shecan.ir/ip/")
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	ip, err := io.ReadAll(resp.Body) // Replaces ioutil.ReadAll
-	if err != nil {
-		return "", err
-	}
-
-	return strings.TrimSpace(string(ip)), nil
-}
-
 // getSystemInfo gathers CPU, Memory, and Disk usage
 func getSystemInfo() (string, string, string) {
 	var cpuInfo, memoryInfo, diskInfo string
 
 	switch runtime.GOOS {
-	case "linux", "darwin":
-		cpuInfoBytes, _ := exec.Command("sh", "-c", "sysctl -n machdep.cpu.brand_string || cat /proc/cpuinfo | grep 'model name' | uniq").Output()
-		cpuInfo = strings.TrimSpace(string(cpuInfoBytes))
-
-		memoryBytes, _ := exec.Command("sh", "-c", "vm_stat | grep 'Pages free' || free -h | grep Mem").Output()
-		memoryInfo = strings.TrimSpace(string(memoryBytes))
-
-		diskBytes, _ := exec.Command("sh", "-c", "df -h | grep '/$'").Output()
-		diskInfo = strings.TrimSpace(string(diskBytes))
+	case "linux":
+		if data, err := os.ReadFile("/proc/cpuinfo"); err == nil {
+			cpuInfo = strings.Join(unique(matchingLines(string(data), "model name")), "\n")
+		}
+		memoryInfo = strings.Join(matchingLines(commandOutput("free", "-h"), "Mem"), "\n")
+		diskInfo = lastLine(commandOutput("df", "-h", "/"))
+	case "darwin":
+		cpuInfo = strings.TrimSpace(commandOutput("sysctl", "-n", "machdep.cpu.brand_string"))
+		memoryInfo = strings.Join(matchingLines(commandOutput("vm_stat"), "Pages free"), "\n")
+		diskInfo = lastLine(commandOutput("df", "-h", "/"))
 	case "windows":
 		cpuInfo = "Windows CPU Info"
 		memoryInfo = "Windows Memory Info"
@@ -106,32 +116,95 @@ func getSystemInfo() (string, string, string) {
 	return cpuInfo, memoryInfo, diskInfo
 }
 
+// commandOutput runs a short system command and returns its stdout, or "" on failure
+func commandOutput(name string, args ...string) string {
+	result, err := runCommand(5*time.Second, name, args...)
+	if err != nil {
+		return ""
+	}
+	return result.Stdout
+}
+
+// matchingLines returns the trimmed lines of output containing substr
+func matchingLines(output, substr string) []string {
+	var lines []string
+	for _, line := range strings.Split(output, "\n") {
+		if strings.Contains(line, substr) {
+			lines = append(lines, strings.TrimSpace(line))
+		}
+	}
+	return lines
+}
+
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+var macNameserverPattern = regexp.MustCompile(`^\s*nameserver\[\d+\]\s*:\s*(\S+)`)
+
 // getDNSServers retrieves the DNS servers configured in the OS
 func getDNSServers() ([]string, error) {
-	var dnsServers []string
-	var cmd *exec.Cmd
+	var servers []string
 
 	switch runtime.GOOS {
 	case "linux":
-		cmd = exec.Command("sh", "-c", "cat /etc/resolv.conf | grep nameserver | awk '{print $2}'")
+		data, err := os.ReadFile("/etc/resolv.conf")
+		if err != nil {
+			return nil, err
+		}
+		servers = parseResolvConf(string(data))
 	case "darwin":
-		cmd = exec.Command("sh", "-c", "scutil --dns | grep 'nameserver\\[[0-9]\\]' | awk '{print $3}'")
+		result, err := runCommand(5*time.Second, "scutil", "--dns")
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(result.Stdout, "\n") {
+			if m := macNameserverPattern.FindStringSubmatch(line); m != nil {
+				servers = append(servers, m[1])
+			}
+		}
 	case "windows":
-		cmd = exec.Command("powershell", "-Command", "Get-DnsClientServerAddress | Select-Object -ExpandProperty ServerAddresses | Where-Object { $_ -match '^\\d{1,3}(\\.\\d{1,3}){3}$' }")
+		result, err := runCommand(10*time.Second, "powershell", "-NoProfile", "-Command", "Get-DnsClientServerAddress | Select-Object -ExpandProperty ServerAddresses")
+		if err != nil {
+			return nil, err
+		}
+		servers = parseWindowsDNSServers(result.Stdout)
 	default:
 		return nil, fmt.Errorf("unsupported OS")
 	}
 
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, err
+	return unique(servers), nil
+}
+
+// parseResolvConf returns the nameserver entries of a resolv.conf file
+func parseResolvConf(data string) []string {
+	var servers []string
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			servers = append(servers, fields[1])
+		}
 	}
-	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
-	for i, line := range lines {
-		lines[i] = strings.TrimSpace(line)
+	return servers
+}
+
+// windowsPlaceholderDNS are the site-local addresses Windows lists on IPv6 connections
+// without a configured resolver; nothing answers on them
+var windowsPlaceholderDNS = []string{"fec0:0:0:ffff::1", "fec0:0:0:ffff::2", "fec0:0:0:ffff::3"}
+
+// parseWindowsDNSServers reads the ServerAddresses printed by Get-DnsClientServerAddress.
+// IPv6 resolvers are kept so an IPv6 DNS leak shows up in the findings.
+func parseWindowsDNSServers(output string) []string {
+	var servers []string
+	for _, line := range strings.Split(output, "\n") {
+		ip := net.ParseIP(strings.TrimSpace(line))
+		if ip == nil || contains(windowsPlaceholderDNS, ip.String()) {
+			continue
+		}
+		servers = append(servers, ip.String())
 	}
-	dnsServers = unique(lines)
-	return dnsServers, nil
+	return servers
 }
 
 func unique(elements []string) []string {
@@ -170,7 +243,7 @@ func sendReport(report Report) error {
 		return err
 	}
 
-	resp, err := http.Post(serverURL, "application/json", bytes.NewBuffer(jsonData))
+	resp, err := newHTTPClient(DefaultConfig.Timeout, "").Post(serverURL, "application/json", bytes.NewBuffer(jsonData))
 	if err != nil {
 		return err
 	}
@@ -186,7 +259,6 @@ func sendReport(report Report) error {
 func initReport() Report {
 	hostname, _ := os.Hostname()
 	localIPs, _ := getLocalIPs()
-	publicIP, _ := getPublicIP()
 	cpu, memory, disk := getSystemInfo()
 	dnsServers, _ := getDNSServers()
 
@@ -194,7 +266,7 @@ func initReport() Report {
 		Hostname:   hostname,
 		OS:         runtime.GOOS,
 		IPs:        localIPs,
-		PublicIP:   publicIP,
+		Network:    collectNetworkInventory(),
 		CPUInfo:    cpu,
 		DiskInfo:   disk,
 		MemoryInfo: memory,
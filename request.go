@@ -2,10 +2,8 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
 	"fmt"
 	"io"
-	"net"
 	"net/http"
 	"net/http/cookiejar"
 	"strconv"
@@ -23,18 +21,24 @@ func (e *RequestError) Error() string {
 	return fmt.Sprintf("request failed with status %d: %v", e.StatusCode, e.Err)
 }
 
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
 // RequestConfig holds the configuration for HTTP requests
 type RequestConfig struct {
-	Timeout    time.Duration
-	MaxRetries int
-	RetryDelay time.Duration
+	Timeout       time.Duration
+	MaxRetries    int
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
 }
 
 // DefaultConfig provides sensible default values
 var DefaultConfig = RequestConfig{
-	Timeout:    10 * time.Second,
-	MaxRetries: 3,
-	RetryDelay: 100 * time.Millisecond,
+	Timeout:       10 * time.Second,
+	MaxRetries:    3,
+	RetryDelay:    100 * time.Millisecond,
+	MaxRetryDelay: 5 * time.Second,
 }
 
 var sharedCookieJar http.CookieJar
@@ -78,46 +82,74 @@ func HTTPRequestWithContext(ctx context.Context, url string, rest ...string) (*h
 
 	var resp *http.Response
 	var lastErr error
+	var policy RetryPolicy
+	var pendingChallenge *ChallengeEvent
 	challengeRetried := false
 
-	for attempt := 0; attempt <= config.MaxRetries; attempt++ {
-		select {
-		case <-ctx.Done():
-			return nil, ctx.Err()
-		default:
-			if attempt > 0 {
-				time.Sleep(time.Duration(attempt) * config.RetryDelay)
-			}
+	for attempt := 0; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 
-			req, err := prepareRequest(ctx, url, rest...)
-			if err != nil {
-				return nil, fmt.Errorf("failed to prepare request: %w", err)
-			}
+		req, err := prepareRequest(ctx, url, rest...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare request: %w", err)
+		}
 
+		if overrideHost != "" {
+			req.Host = overrideHost
+		}
+		if policy == nil {
+			// over-IP requests name the real host only in the Host header
+			host := req.URL.Hostname()
 			if overrideHost != "" {
-				req.Host = overrideHost
+				host = overrideHost
 			}
+			policy = retryPolicyFor(host, config)
+		}
 
-			resp, lastErr = client.Do(req)
-			if lastErr == nil {
-				if shouldRetryForChallenge(resp, req.URL.Hostname(), challengeRetried) {
-					challengeRetried = true
-					discardResponse(resp)
-					attempt--
-					continue
-				}
-
-				if resp.StatusCode < 500 {
-					return resp, nil
-				}
+		resp, lastErr = client.Do(req)
+		if pendingChallenge != nil {
+			pendingChallenge.finishRetry(resp, lastErr)
+			recordChallenge(*pendingChallenge)
+			pendingChallenge = nil
+		}
+		if lastErr == nil {
+			if shouldRetryForChallenge(resp, req.URL.Hostname(), challengeRetried) {
+				challengeRetried = true
+				pendingChallenge = newChallengeEvent(req.URL.Hostname(), ChallengeCookie, resp)
+				discardResponse(resp)
+				attempt--
+				continue
 			}
+			if signature := detectInterstitial(resp); signature != "" {
+				event := newChallengeEvent(req.URL.Hostname(), ChallengeInterstitial, resp)
+				event.Signature = signature
+				recordChallenge(*event)
+			}
+		}
 
-			if resp != nil {
-				resp.Body.Close()
+		delay, retry := policy.Next(attempt, resp, lastErr)
+		if !retry {
+			if lastErr == nil && resp.StatusCode < 500 {
+				return resp, nil
 			}
+			break
+		}
+
+		recordRetryAttempt(url, attempt, resp, lastErr, delay)
+		if resp != nil {
+			discardResponse(resp)
+		}
+		if err := sleepContext(ctx, delay); err != nil {
+			return nil, err
 		}
 	}
 
+	if resp != nil {
+		resp.Body.Close()
+	}
+
 	return nil, &RequestError{
 		StatusCode: getStatusCode(resp),
 		Err:        lastErr,
@@ -164,20 +196,9 @@ func getStatusCode(resp *http.Response) int {
 }
 
 func newHTTPClient(timeout time.Duration, overrideHost string) *http.Client {
-	transport := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			ServerName: overrideHost,
-			// InsecureSkipVerify: true, // Uncomment if using self-signed certs
-		},
-		DialContext: (&net.Dialer{
-			Timeout:   timeout,
-			KeepAlive: 30 * time.Second,
-		}).DialContext,
-	}
-
 	client := &http.Client{
 		Timeout:   timeout,
-		Transport: transport,
+		Transport: newTransport(timeout, overrideHost),
 	}
 	if sharedCookieJar != nil {
 		client.Jar = sharedCookieJar
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RetryPolicy decides whether a failed attempt should be retried and how long to wait first
+type RetryPolicy interface {
+	// Next is called after attempt (0 based) failed with resp or err.
+	// It returns the delay before the next attempt and false when no retry should happen.
+	Next(attempt int, resp *http.Response, err error) (time.Duration, bool)
+}
+
+// BackoffPolicy retries transport errors, 5xx and 429 with exponential backoff and jitter.
+// A Retry-After header on 429/503 overrides the computed delay, capped at MaxRetryAfter.
+type BackoffPolicy struct {
+	MaxRetries    int
+	BaseDelay     time.Duration
+	MaxDelay      time.Duration
+	Multiplier    float64
+	Jitter        float64 // fraction of the delay randomised in both directions, 0..1
+	MaxRetryAfter time.Duration
+}
+
+// Next implements RetryPolicy
+func (p BackoffPolicy) Next(attempt int, resp *http.Response, err error) (time.Duration, bool) {
+	if attempt >= p.MaxRetries {
+		return 0, false
+	}
+	if err == nil && resp != nil && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+
+	if resp != nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) {
+		if wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			if p.MaxRetryAfter > 0 && wait > p.MaxRetryAfter {
+				wait = p.MaxRetryAfter
+			}
+			return wait, true
+		}
+	}
+
+	return p.backoff(attempt), true
+}
+
+func (p BackoffPolicy) backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	delay := float64(p.BaseDelay) * math.Pow(multiplier, float64(attempt))
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		delay += delay * p.Jitter * (2*rand.Float64() - 1)
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	return time.Duration(delay)
+}
+
+// parseRetryAfter understands both the delta-seconds and HTTP-date forms of Retry-After
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if wait := at.Sub(now); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// sleepContext waits for d or until ctx is done, whichever comes first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+var (
+	retryPoliciesMu sync.RWMutex
+	retryPolicies   = map[string]RetryPolicy{
+		// check.shecan.ir rate limits aggressively, so give it more room
+		"check.shecan.ir": BackoffPolicy{
+			MaxRetries:    4,
+			BaseDelay:     500 * time.Millisecond,
+			MaxDelay:      10 * time.Second,
+			Multiplier:    2,
+			Jitter:        0.2,
+			MaxRetryAfter: 30 * time.Second,
+		},
+	}
+)
+
+// SetRetryPolicy overrides the retry policy for requests to host
+func SetRetryPolicy(host string, policy RetryPolicy) {
+	retryPoliciesMu.Lock()
+	defer retryPoliciesMu.Unlock()
+	retryPolicies[strings.ToLower(host)] = policy
+}
+
+// retryPolicyFor returns the override for host, or a backoff policy derived from config
+func retryPolicyFor(host string, config RequestConfig) RetryPolicy {
+	retryPoliciesMu.RLock()
+	policy, ok := retryPolicies[strings.ToLower(host)]
+	retryPoliciesMu.RUnlock()
+	if ok {
+		return policy
+	}
+	return BackoffPolicy{
+		MaxRetries:    config.MaxRetries,
+		BaseDelay:     config.RetryDelay,
+		MaxDelay:      config.MaxRetryDelay,
+		Multiplier:    2,
+		Jitter:        0.2,
+		MaxRetryAfter: config.MaxRetryDelay,
+	}
+}
+
+// RetryAttempt records a single retry made by HTTPRequestWithContext
+type RetryAttempt struct {
+	URL     string `json:"url"`
+	Attempt int    `json:"attempt"`
+	Status  int    `json:"status,omitempty"`
+	Error   string `json:"error,omitempty"`
+	DelayMs int64  `json:"delay_ms"`
+	At      string `json:"at"`
+}
+
+var retryLogMu sync.Mutex
+
+func recordRetryAttempt(url string, attempt int, resp *http.Response, err error, delay time.Duration) {
+	entry := RetryAttempt{
+		URL:     url,
+		Attempt: attempt + 1,
+		DelayMs: delay.Milliseconds(),
+		At:      time.Now().Format(time.RFC3339),
+	}
+	if resp != nil {
+		entry.Status = resp.StatusCode
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	retryLogMu.Lock()
+	defer retryLogMu.Unlock()
+	report.Retries = append(report.Retries, entry)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func statusResponse(code int, retryAfter string) *http.Response {
+	resp := &http.Response{StatusCode: code, Header: http.Header{}}
+	if retryAfter != "" {
+		resp.Header.Set("Retry-After", retryAfter)
+	}
+	return resp
+}
+
+func TestBackoffPolicy(t *testing.T) {
+	policy := BackoffPolicy{MaxRetries: 6, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second, Multiplier: 2}
+	for attempt, want := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		delay, retry := policy.Next(attempt, nil, errors.New("connection reset"))
+		if !retry || delay != want*time.Millisecond {
+			t.Errorf("attempt %d: %v, %v", attempt, delay, retry)
+		}
+	}
+	if _, retry := policy.Next(6, nil, errors.New("connection reset")); retry {
+		t.Error("retried past MaxRetries")
+	}
+
+	for code, want := range map[int]bool{200: false, 404: false, 429: true, 500: true, 502: true} {
+		if _, retry := policy.Next(0, statusResponse(code, ""), nil); retry != want {
+			t.Errorf("status %d: retry = %v", code, retry)
+		}
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	policy := BackoffPolicy{MaxRetries: 1, BaseDelay: time.Second, Multiplier: 2, Jitter: 0.2}
+	seen := map[time.Duration]bool{}
+	for i := 0; i < 200; i++ {
+		delay, _ := policy.Next(0, nil, errors.New("timeout"))
+		if delay < 800*time.Millisecond || delay > 1200*time.Millisecond {
+			t.Fatalf("delay %v outside 20%% of 1s", delay)
+		}
+		seen[delay] = true
+	}
+	if len(seen) < 2 {
+		t.Error("jitter did not vary the delay")
+	}
+}
+
+func TestRetryAfterCap(t *testing.T) {
+	policy := BackoffPolicy{MaxRetries: 3, BaseDelay: 100 * time.Millisecond, Multiplier: 2, MaxRetryAfter: 30 * time.Second}
+	for name, tc := range map[string]struct {
+		resp *http.Response
+		want time.Duration
+	}{
+		"seconds":        {statusResponse(429, "2"), 2 * time.Second},
+		"capped":         {statusResponse(503, "120"), 30 * time.Second},
+		"capped date":    {statusResponse(429, time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)), 30 * time.Second},
+		"invalid":        {statusResponse(429, "soon"), 100 * time.Millisecond},
+		"ignored on 500": {statusResponse(500, "120"), 100 * time.Millisecond},
+	} {
+		if delay, retry := policy.Next(0, tc.resp, nil); !retry || delay != tc.want {
+			t.Errorf("%s: %v, %v", name, delay, retry)
+		}
+	}
+
+	policy.MaxRetryAfter = 0
+	if delay, _ := policy.Next(0, statusResponse(429, "120"), nil); delay != 120*time.Second {
+		t.Errorf("uncapped Retry-After = %v", delay)
+	}
+}
+
+// retryPolicyFunc lets a test see which requests consult a policy
+type retryPolicyFunc func(attempt int, resp *http.Response, err error) (time.Duration, bool)
+
+func (f retryPolicyFunc) Next(attempt int, resp *http.Response, err error) (time.Duration, bool) {
+	return f(attempt, resp, err)
+}
+
+func TestRetryPolicyForOverIPRequests(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	calls := 0
+	SetRetryPolicy("policy.test", retryPolicyFunc(func(int, *http.Response, error) (time.Duration, bool) {
+		calls++
+		return 0, false
+	}))
+	t.Cleanup(func() {
+		retryPoliciesMu.Lock()
+		delete(retryPolicies, "policy.test")
+		retryPoliciesMu.Unlock()
+	})
+
+	// the URL names the IP, the Host header the site whose policy applies
+	if _, err := HTTPRequest(server.URL+"/", "GET", "", "Host: policy.test", "2"); err == nil {
+		t.Fatal("expected the 503 to fail the request")
+	}
+	if calls != 1 {
+		t.Errorf("policy consulted %d times", calls)
+	}
+}
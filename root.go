@@ -7,6 +7,12 @@ import (
 // PlanFlag stores the selected diagnostic plan from the CLI flag.
 var PlanFlag string
 
+// ProxyFlag and NoProxyFlag select the proxy policy for every HTTP request.
+var (
+	ProxyFlag   string
+	NoProxyFlag bool
+)
+
 var rootCmd = &cobra.Command{
 	Use:   "diagnostic",
 	Short: "Run DNS diagnostic tool",
@@ -21,4 +27,7 @@ func Execute() error {
 
 func init() {
 	rootCmd.PersistentFlags().StringVarP(&PlanFlag, "plan", "p", "", "Select plan (Free or Pro)")
+	rootCmd.PersistentFlags().StringVar(&ProxyFlag, "proxy", "", "Send all requests through this proxy URL")
+	rootCmd.PersistentFlags().BoolVar(&NoProxyFlag, "no-proxy", false, "Ignore proxy environment variables and connect directly")
+	rootCmd.MarkFlagsMutuallyExclusive("proxy", "no-proxy")
 }
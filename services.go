@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Service check outcomes
+const (
+	ServiceUnblocked   = "unblocked"
+	ServiceGeoBlocked  = "geo_blocked"
+	ServiceFiltered    = "filtered"
+	ServiceUnreachable = "unreachable"
+)
+
+//go:embed targets/services.json
+var defaultServices []byte
+
+// ServiceExpectation is the signal that tells us a service answered normally
+type ServiceExpectation struct {
+	Status       []int  `json:"status,omitempty"`
+	BodyContains string `json:"body_contains,omitempty"`
+	Header       string `json:"header,omitempty"` // "Name" to require presence, "Name: value" to require a substring
+}
+
+// ServiceTarget is one sanctioned service we expect Shecan to unblock
+type ServiceTarget struct {
+	Name   string             `json:"name"`
+	URL    string             `json:"url"`
+	Expect ServiceExpectation `json:"expect"`
+}
+
+// ServiceList is the content of the services data file
+type ServiceList struct {
+	Version  int             `json:"version"`
+	Services []ServiceTarget `json:"services"`
+}
+
+// ServiceCheck is the result of checking one sanctioned service
+type ServiceCheck struct {
+	Name           string         `json:"name"`
+	URL            string         `json:"url"`
+	Domain         string         `json:"domain"`
+	ResolvedIPs    []string       `json:"resolved_ips"`
+	ResolveError   string         `json:"resolve_error,omitempty"`
+	Steering       string         `json:"steering,omitempty"`
+	ShecanIPs      []string       `json:"shecan_ips,omitempty"`
+	Code           int            `json:"code,omitempty"`
+	Outcome        string         `json:"outcome"`
+	Classification Classification `json:"classification"`
+}
+
+// loadServices reads SERVICE_TARGETS if set, falling back to the embedded list
+func loadServices() ServiceList {
+	var list ServiceList
+	if path := os.Getenv("SERVICE_TARGETS"); path != "" {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			if err = json.Unmarshal(data, &list); err == nil {
+				return list
+			}
+		}
+		fmt.Println(colorMap["yellow"], "[Warning] Can't load service targets, using built-in list:", err)
+	}
+	list = ServiceList{}
+	_ = json.Unmarshal(defaultServices, &list)
+	return list
+}
+
+func (e ServiceExpectation) matches(response *http.Response, body string) bool {
+	if len(e.Status) > 0 && !containsInt(e.Status, response.StatusCode) {
+		return false
+	}
+	if e.BodyContains != "" && !strings.Contains(strings.ToLower(body), strings.ToLower(e.BodyContains)) {
+		return false
+	}
+	if e.Header != "" {
+		name, value, hasValue := strings.Cut(e.Header, ":")
+		got := response.Header.Values(strings.TrimSpace(name))
+		if len(got) == 0 {
+			return false
+		}
+		if hasValue && !strings.Contains(strings.Join(got, ","), strings.TrimSpace(value)) {
+			return false
+		}
+	}
+	return true
+}
+
+// isBlockPage reports whether a response was recognised as a filter, sanctions or login
+// page. Those often answer 200, so no expectation can turn them into a pass.
+func isBlockPage(class Classification) bool {
+	switch class.Category {
+	case CategoryFiltered, CategoryGeoBlocked, CategoryCaptivePortal:
+		return true
+	}
+	return false
+}
+
+// serviceOutcome maps a classification onto the service outcome when the expectation failed
+func serviceOutcome(class Classification, code int) string {
+	switch class.Category {
+	case CategoryGeoBlocked:
+		return ServiceGeoBlocked
+	case CategoryFiltered, CategoryTCPReset, CategoryTLSReset:
+		return ServiceFiltered
+	}
+	if code == http.StatusForbidden || code == http.StatusUnavailableForLegalReasons {
+		return ServiceGeoBlocked
+	}
+	return ServiceUnreachable
+}
+
+func checkService(service ServiceTarget) ServiceCheck {
+	result := ServiceCheck{Name: service.Name, URL: service.URL}
+	if u, err := url.Parse(service.URL); err == nil {
+		result.Domain = u.Hostname()
+	}
+
+	// resolve through the OS resolver so we see what Shecan (or whatever is active) answers
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	addrs, err := lookupIPAddr(ctx, result.Domain)
+	cancel()
+	if err != nil {
+		result.ResolveError = err.Error()
+	}
+	for _, addr := range addrs {
+		result.ResolvedIPs = append(result.ResolvedIPs, addr.IP.String())
+	}
+
+	response, err := HTTPRequest(service.URL, "GET", "", "", "5")
+	if err != nil {
+		result.Classification = classifyError(err)
+		result.Outcome = serviceOutcome(result.Classification, 0)
+		return result
+	}
+	defer response.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	result.Code = response.StatusCode
+	result.Classification = classifyResponse(response, string(body))
+	if service.Expect.matches(response, string(body)) && !isBlockPage(result.Classification) {
+		result.Outcome = ServiceUnblocked
+		if result.Classification.Category == CategoryHTTPError {
+			// the status is the one the service answers when it works, e.g. Docker's 401
+			result.Classification = Classification{Category: CategoryOK, Detail: "expected " + response.Status}
+		}
+	} else {
+		result.Outcome = serviceOutcome(result.Classification, response.StatusCode)
+	}
+	return result
+}
+
+func performServiceChecks(services []ServiceTarget) []ServiceCheck {
+	results := make([]ServiceCheck, len(services))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxHTTPConcurrency)
+
+	for i, service := range services {
+		wg.Add(1)
+		go func(i int, s ServiceTarget) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			results[i] = checkService(s)
+			printServiceCheck(results[i])
+		}(i, service)
+	}
+
+	wg.Wait()
+	return results
+}
+
+func printServiceCheck(check ServiceCheck) {
+	ips := strings.Join(check.ResolvedIPs, ", ")
+	switch check.Outcome {
+	case ServiceUnblocked:
+		fmt.Println(colorMap["green"], "[Success]", check.Name, "unblocked via Shecan", "("+ips+")")
+	case ServiceGeoBlocked:
+		fmt.Println(colorMap["red"], "[Error]", check.Name, "is geo-blocked", "("+ips+")")
+	case ServiceFiltered:
+		fmt.Println(colorMap["red"], "[Error]", check.Name, "is filtered", "("+ips+")", check.Classification.Category)
+	default:
+		fmt.Println(colorMap["yellow"], "[Warning]", check.Name, "unreachable", "("+ips+")", check.Classification.Detail)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBuiltinServicesExpectContent(t *testing.T) {
+	var list ServiceList
+	if err := json.Unmarshal(defaultServices, &list); err != nil {
+		t.Fatal(err)
+	}
+	for _, service := range list.Services {
+		// a filter or login page answers 200 too, so the status alone proves nothing
+		if service.Expect.BodyContains == "" && service.Expect.Header == "" {
+			t.Errorf("%s only expects a status", service.Name)
+		}
+	}
+}
+
+func TestCheckService(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/registry/":
+			w.Header().Set("Docker-Distribution-Api-Version", "registry/2.0")
+			w.WriteHeader(http.StatusUnauthorized)
+		case "/filtered/":
+			fmt.Fprint(w, `<html><iframe src="http://10.10.34.35:80"></iframe> Spotify</html>`)
+		case "/hotspot/":
+			fmt.Fprint(w, "<h1>Hotspot login</h1> Gemini")
+		default:
+			fmt.Fprint(w, "<title>Gemini</title>")
+		}
+	}))
+	defer server.Close()
+
+	for name, tc := range map[string]struct {
+		path     string
+		expect   ServiceExpectation
+		outcome  string
+		category string
+	}{
+		"working":      {"/", ServiceExpectation{Status: []int{200}, BodyContains: "Gemini"}, ServiceUnblocked, CategoryOK},
+		"expected 401": {"/registry/", ServiceExpectation{Status: []int{401}, Header: "Docker-Distribution-Api-Version"}, ServiceUnblocked, CategoryOK},
+		"filter page":  {"/filtered/", ServiceExpectation{Status: []int{200}, BodyContains: "Spotify"}, ServiceFiltered, CategoryFiltered},
+		"login page":   {"/hotspot/", ServiceExpectation{Status: []int{200}}, ServiceUnreachable, CategoryCaptivePortal},
+	} {
+		check := checkService(ServiceTarget{Name: name, URL: server.URL + tc.path, Expect: tc.expect})
+		if check.Outcome != tc.outcome || check.Classification.Category != tc.category {
+			t.Errorf("%s: outcome %s, classification %+v", name, check.Outcome, check.Classification)
+		}
+	}
+}
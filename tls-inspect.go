@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// CertInfo describes a single certificate presented by a peer
+type CertInfo struct {
+	Subject    string   `json:"subject"`
+	Issuer     string   `json:"issuer"`
+	SANs       []string `json:"sans,omitempty"`
+	NotBefore  string   `json:"not_before"`
+	NotAfter   string   `json:"not_after"`
+	SPKISHA256 string   `json:"spki_sha256"` // base64 encoded SHA-256 of the SubjectPublicKeyInfo
+}
+
+// TLSInspection holds the certificate chain seen for an over-IP check and what was wrong with it
+type TLSInspection struct {
+	ServerName   string     `json:"server_name"`
+	Chain        []CertInfo `json:"chain,omitempty"`
+	Verified     bool       `json:"verified"`
+	Intercepted  bool       `json:"intercepted"`
+	Expired      bool       `json:"expired"`
+	NameMismatch bool       `json:"name_mismatch"`
+	PinMatched   bool       `json:"pin_matched"`
+	Proxy        string     `json:"proxy,omitempty"` // the proxy the chain was fetched through, like the HTTP check
+	Error        string     `json:"error,omitempty"`
+}
+
+// expectedTLSIssuers returns the issuer common names we accept for Shecan endpoints
+func expectedTLSIssuers() []string {
+	return envList("TLS_EXPECTED_ISSUERS")
+}
+
+// expectedTLSPins returns the base64 SPKI SHA-256 pins we accept anywhere in the chain
+func expectedTLSPins() []string {
+	return envList("TLS_SPKI_PINS")
+}
+
+func spkiFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func describeCert(cert *x509.Certificate) CertInfo {
+	sans := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	return CertInfo{
+		Subject:    cert.Subject.String(),
+		Issuer:     cert.Issuer.String(),
+		SANs:       sans,
+		NotBefore:  cert.NotBefore.UTC().Format(time.RFC3339),
+		NotAfter:   cert.NotAfter.UTC().Format(time.RFC3339),
+		SPKISHA256: spkiFingerprint(cert),
+	}
+}
+
+// inspectTLS dials ip:443 with the given SNI, through the proxy in force like the HTTP
+// check, and classifies the presented chain. Verification is skipped during the handshake
+// so the chain is captured even when it is bad.
+func inspectTLS(ip, serverName string, timeout time.Duration) TLSInspection {
+	result := TLSInspection{ServerName: serverName}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	rawConn, proxy, err := dialThroughProxy(ctx, timeout, net.JoinHostPort(ip, "443"))
+	if proxy != nil {
+		result.Proxy = proxy.Redacted()
+	}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	conn := tls.Client(rawConn, &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: true,
+	})
+	defer conn.Close()
+	if err := conn.HandshakeContext(ctx); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result = classifyChain(conn.ConnectionState().PeerCertificates, serverName, time.Now(), tlsRootCAs)
+	if proxy != nil {
+		result.Proxy = proxy.Redacted()
+	}
+	return result
+}
+
+// classifyChain fills a TLSInspection from a peer chain. roots may be nil to use the system pool.
+func classifyChain(certs []*x509.Certificate, serverName string, now time.Time, roots *x509.CertPool) TLSInspection {
+	result := TLSInspection{ServerName: serverName}
+	if len(certs) == 0 {
+		result.Error = "no peer certificates"
+		return result
+	}
+
+	for _, cert := range certs {
+		result.Chain = append(result.Chain, describeCert(cert))
+	}
+
+	leaf := certs[0]
+	result.Expired = now.After(leaf.NotAfter) || now.Before(leaf.NotBefore)
+	result.NameMismatch = leaf.VerifyHostname(serverName) != nil
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{
+		DNSName:       serverName,
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+	})
+	result.Verified = err == nil
+	if err != nil {
+		result.Error = err.Error()
+		var unknownAuthority x509.UnknownAuthorityError
+		if errors.As(err, &unknownAuthority) {
+			result.Intercepted = true
+		}
+	}
+
+	if issuers := expectedTLSIssuers(); len(issuers) > 0 && !issuerExpected(leaf, issuers) {
+		result.Intercepted = true
+	}
+
+	if pins := expectedTLSPins(); len(pins) > 0 {
+		for _, info := range result.Chain {
+			if contains(pins, info.SPKISHA256) {
+				result.PinMatched = true
+				break
+			}
+		}
+		if !result.PinMatched {
+			result.Intercepted = true
+		}
+	}
+
+	return result
+}
+
+func issuerExpected(leaf *x509.Certificate, issuers []string) bool {
+	for _, issuer := range issuers {
+		if strings.EqualFold(leaf.Issuer.CommonName, issuer) || strings.EqualFold(leaf.Issuer.String(), issuer) {
+			return true
+		}
+	}
+	return false
+}
+
+// reportTLSProblems prints a line for each issue found in the inspection
+func reportTLSProblems(ip string, inspection TLSInspection) {
+	if inspection.Intercepted {
+		issuer := ""
+		if len(inspection.Chain) > 0 {
+			issuer = inspection.Chain[0].Issuer
+		}
+		fmt.Println(colorMap["red"], "[Error] Possible TLS interception on", ip, "issuer:", issuer)
+	}
+	if inspection.Expired {
+		fmt.Println(colorMap["red"], "[Error] Expired certificate presented by", ip)
+	}
+	if inspection.NameMismatch {
+		fmt.Println(colorMap["red"], "[Error] Certificate name mismatch on", ip, "for", inspection.ServerName)
+	}
+}
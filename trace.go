@@ -0,0 +1,367 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Trace probe protocols
+const (
+	TraceProtoICMP = "icmp"
+	TraceProtoUDP  = "udp"
+	TraceProtoTCP  = "tcp"
+)
+
+const (
+	traceUDPBasePort    = 33434 // classic traceroute destination ports, the TTL is added to it
+	traceTCPPort        = 443
+	maxTraceConcurrency = 2
+)
+
+// TraceOptions controls how a path is traced
+type TraceOptions struct {
+	Proto   string
+	Rounds  int
+	MaxHops int
+	Timeout time.Duration
+}
+
+// traceOptionsFromEnv reads TRACE_PROTO, TRACE_ROUNDS, TRACE_MAX_HOPS and TRACE_TIMEOUT.
+// rounds is used when TRACE_ROUNDS is unset; TRACE_ROUNDS=0 turns tracing off in the diagnostic run.
+func traceOptionsFromEnv(rounds int) TraceOptions {
+	return TraceOptions{
+		Proto:   strings.ToLower(envString("TRACE_PROTO", TraceProtoICMP)),
+		Rounds:  envInt("TRACE_ROUNDS", rounds),
+		MaxHops: envInt("TRACE_MAX_HOPS", 20),
+		Timeout: envDuration("TRACE_TIMEOUT", 2*time.Second),
+	}
+}
+
+// maxTraceRounds keeps the UDP destination ports, which encode round and TTL, below 65536
+const maxTraceRounds = 100
+
+// normalize clamps the options to what the probes can encode
+func (o TraceOptions) normalize() TraceOptions {
+	if o.Rounds < 1 {
+		o.Rounds = 1
+	}
+	if o.Rounds > maxTraceRounds {
+		o.Rounds = maxTraceRounds
+	}
+	if o.MaxHops < 1 || o.MaxHops > 255 {
+		o.MaxHops = 30
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 2 * time.Second
+	}
+	switch o.Proto {
+	case TraceProtoICMP, TraceProtoUDP, TraceProtoTCP:
+	default:
+		o.Proto = TraceProtoICMP
+	}
+	return o
+}
+
+// traceProbeID numbers a probe so its answer can be matched to the round and TTL it was sent with
+func traceProbeID(round, ttl int) int {
+	return round<<8 | ttl
+}
+
+// TraceHop aggregates every probe sent with one TTL. Addrs has more than one entry
+// when the hop is load balanced.
+type TraceHop struct {
+	TTL   int      `json:"ttl"`
+	Addrs []string `json:"addrs,omitempty"`
+	PingStats
+}
+
+// TraceResult is the path to one Shecan server
+type TraceResult struct {
+	Target  string     `json:"target"`
+	Proto   string     `json:"proto"`
+	Method  string     `json:"method"` // raw socket or the traceroute binary
+	Rounds  int        `json:"rounds"`
+	Reached bool       `json:"reached"`
+	Hops    []TraceHop `json:"hops"`
+	Error   string     `json:"error,omitempty"`
+}
+
+// traceEvent is one answer to a probe: a hop's Time Exceeded or the target itself
+type traceEvent struct {
+	probe   int
+	from    string
+	reached bool
+	at      time.Time
+}
+
+// hopAccumulator collects the answers for one TTL across rounds
+type hopAccumulator struct {
+	sent  int
+	rtts  []float64
+	addrs []string
+}
+
+func (h *hopAccumulator) add(from string, rtt time.Duration) {
+	h.rtts = append(h.rtts, float64(rtt.Microseconds())/1000)
+	h.addrs = appendAddr(h.addrs, from)
+}
+
+func appendAddr(addrs []string, addr string) []string {
+	for _, a := range addrs {
+		if a == addr {
+			return addrs
+		}
+	}
+	return append(addrs, addr)
+}
+
+// buildTraceResult turns the accumulators for TTL 1..lastTTL into hops
+func buildTraceResult(result TraceResult, hops []hopAccumulator, lastTTL int) TraceResult {
+	for ttl := 1; ttl <= lastTTL && ttl < len(hops); ttl++ {
+		acc := hops[ttl]
+		stats := newPingStats(PingResult{Method: result.Proto, Sent: acc.sent, Received: len(acc.rtts), RTTs: acc.rtts})
+		result.Hops = append(result.Hops, TraceHop{TTL: ttl, Addrs: acc.addrs, PingStats: stats})
+	}
+	return result
+}
+
+// stripIPv4Header drops a leading IPv4 header, which some platforms include on raw reads
+func stripIPv4Header(b []byte) []byte {
+	if len(b) >= 20 && b[0]>>4 == 4 {
+		if headerLen := int(b[0]&0x0f) * 4; len(b) >= headerLen {
+			return b[headerLen:]
+		}
+	}
+	return b
+}
+
+// quotedPacket is the start of the datagram an ICMP error was sent about
+type quotedPacket struct {
+	icmpType, icmpCode byte
+	proto              byte
+	dst                net.IP
+	payload            []byte // at least the first 8 bytes of the quoted transport header
+}
+
+// parseICMPError decodes a Time Exceeded or Destination Unreachable message
+func parseICMPError(b []byte) (quotedPacket, bool) {
+	b = stripIPv4Header(b)
+	if len(b) < 8+20 || (b[0] != icmpTimeExceeded && b[0] != icmpDestUnreachable) {
+		return quotedPacket{}, false
+	}
+	quoted := b[8:]
+	headerLen := int(quoted[0]&0x0f) * 4
+	if quoted[0]>>4 != 4 || len(quoted) < headerLen+8 {
+		return quotedPacket{}, false
+	}
+	return quotedPacket{
+		icmpType: b[0],
+		icmpCode: b[1],
+		proto:    quoted[9],
+		dst:      net.IP(quoted[16:20]),
+		payload:  quoted[headerLen:],
+	}, true
+}
+
+const (
+	icmpDestUnreachable = 3
+	icmpTimeExceeded    = 11
+)
+
+// matchICMPProbe maps an answer to one of our echo requests back to its probe ID,
+// which is carried in the sequence number
+func matchICMPProbe(b []byte, from, target net.IP, id uint16) (probe int, reached, ok bool) {
+	if replyID, seq, _, isReply := parseEchoReply(b); isReply {
+		return int(seq), true, replyID == id && from.Equal(target)
+	}
+	q, isErr := parseICMPError(b)
+	if !isErr || q.proto != 1 || !q.dst.Equal(target) || binary.BigEndian.Uint16(q.payload[4:]) != id {
+		return 0, false, false
+	}
+	return int(binary.BigEndian.Uint16(q.payload[6:])), q.icmpType == icmpDestUnreachable, true
+}
+
+// matchUDPProbe maps an ICMP error about one of our UDP probes back to its probe ID,
+// which is carried in the destination port
+func matchUDPProbe(b []byte, target net.IP, srcPort int) (probe int, reached, ok bool) {
+	q, isErr := parseICMPError(b)
+	if !isErr || q.proto != 17 || !q.dst.Equal(target) || int(binary.BigEndian.Uint16(q.payload[0:])) != srcPort {
+		return 0, false, false
+	}
+	probe = int(binary.BigEndian.Uint16(q.payload[2:])) - traceUDPBasePort
+	return probe, q.icmpType == icmpDestUnreachable, probe > 0
+}
+
+// matchTCPProbe maps an ICMP error about one of our SYNs back to its probe ID by source port
+func matchTCPProbe(b []byte, target net.IP, ports map[int]int) (probe int, reached, ok bool) {
+	q, isErr := parseICMPError(b)
+	if !isErr || q.proto != 6 || !q.dst.Equal(target) {
+		return 0, false, false
+	}
+	probe, ok = ports[int(binary.BigEndian.Uint16(q.payload[0:]))]
+	return probe, q.icmpType == icmpDestUnreachable, ok
+}
+
+// traceHost traces the path to host with the raw socket engine, falling back to the
+// traceroute binary when raw sockets are not available. PING_ENGINE=binary forces the binary.
+func traceHost(host string, opts TraceOptions) TraceResult {
+	opts = opts.normalize()
+	if os.Getenv("PING_ENGINE") != PingMethodBinary {
+		target, err := resolveIPv4(host, opts.Timeout)
+		if err == nil {
+			result, err := rawTrace(target, opts)
+			if err == nil || !errors.Is(err, errNoICMPSocket) {
+				result.Target = host
+				if err != nil {
+					result.Error = err.Error()
+				}
+				return result
+			}
+		} else if !errors.Is(err, errNativeUnsupported) {
+			return TraceResult{Target: host, Proto: opts.Proto, Rounds: opts.Rounds, Error: err.Error()}
+		}
+	}
+	return binaryTrace(host, opts)
+}
+
+var traceHopPattern = regexp.MustCompile(`^\s*(\d+)\s+(.*)$`)
+
+// binaryTrace runs traceroute, or tracert on Windows, and parses its hop lines
+func binaryTrace(host string, opts TraceOptions) TraceResult {
+	result := TraceResult{Target: host, Proto: opts.Proto, Method: PingMethodBinary, Rounds: opts.Rounds}
+	waitSecs := int(opts.Timeout.Seconds())
+	if waitSecs < 1 {
+		waitSecs = 1
+	}
+
+	name := "traceroute"
+	args := []string{"-n", "-q", strconv.Itoa(opts.Rounds), "-m", strconv.Itoa(opts.MaxHops), "-w", strconv.Itoa(waitSecs)}
+	switch {
+	case runtime.GOOS == "windows":
+		// tracert always sends three ICMP probes per hop
+		name = "tracert"
+		args = []string{"-d", "-h", strconv.Itoa(opts.MaxHops), "-w", strconv.Itoa(waitSecs * 1000)}
+		result.Proto, result.Rounds = TraceProtoICMP, 3
+	case opts.Proto == TraceProtoICMP:
+		args = append(args, "-I")
+	case opts.Proto == TraceProtoTCP:
+		args = append(args, "-T", "-p", strconv.Itoa(traceTCPPort))
+	}
+	args = append(args, host)
+
+	out, err := runCommand(time.Duration(opts.Rounds*opts.MaxHops*waitSecs+5)*time.Second, name, args...)
+	result.Hops = parseTraceOutput(out.Output(), result.Rounds, result.Proto)
+	if len(result.Hops) > 0 {
+		last := result.Hops[len(result.Hops)-1]
+		for _, addr := range last.Addrs {
+			result.Reached = result.Reached || addr == host
+		}
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// parseTraceOutput reads traceroute and tracert hop lines such as
+// " 2  10.10.0.1  3.201 ms * 2.998 ms" and "  2     3 ms    <1 ms     *     10.10.0.1"
+func parseTraceOutput(output string, rounds int, proto string) []TraceHop {
+	var hops []TraceHop
+	for _, line := range strings.Split(output, "\n") {
+		m := traceHopPattern.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		ttl, _ := strconv.Atoi(m[1])
+		var addrs []string
+		var rtts []float64
+		fields := strings.Fields(m[2])
+		for i, field := range fields {
+			if ip := net.ParseIP(field); ip != nil {
+				addrs = appendAddr(addrs, ip.String())
+				continue
+			}
+			if i+1 < len(fields) && fields[i+1] == "ms" {
+				if rtt, err := strconv.ParseFloat(strings.TrimPrefix(field, "<"), 64); err == nil {
+					rtts = append(rtts, rtt)
+				}
+			}
+		}
+		// lines that are neither probes nor addresses, e.g. headers, are not hops
+		if len(addrs) == 0 && !strings.Contains(m[2], "*") {
+			continue
+		}
+		stats := newPingStats(PingResult{Method: proto, Sent: rounds, Received: len(rtts), RTTs: rtts})
+		hops = append(hops, TraceHop{TTL: ttl, Addrs: addrs, PingStats: stats})
+	}
+	return hops
+}
+
+// performTraces traces every host, a few at a time, and prints one line per hop.
+// Hostnames are resolved by traceHost; networks from the IP list cannot be traced.
+func performTraces(hosts []string, opts TraceOptions) []TraceResult {
+	var targets []string
+	for _, raw := range unique(hosts) {
+		host := strings.TrimSpace(raw)
+		switch {
+		case host == "":
+		case strings.Contains(host, "/"):
+			fmt.Println(colorMap["grey"], "[INFO] Not tracing network", host)
+		default:
+			targets = append(targets, host)
+		}
+	}
+
+	results := make([]TraceResult, len(targets))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxTraceConcurrency)
+	for i, host := range targets {
+		wg.Add(1)
+		go func(i int, h string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i] = traceHost(h, opts)
+		}(i, host)
+	}
+	wg.Wait()
+
+	for _, result := range results {
+		printTrace(result)
+	}
+	return results
+}
+
+func printTrace(result TraceResult) {
+	fmt.Printf("%sTrace to %s (%s, %s):\n", colorMap["green"], result.Target, result.Proto, result.Method)
+	if result.Error != "" {
+		fmt.Println(colorMap["red"], "[Error] Trace failed:", result.Error)
+	}
+	for _, hop := range result.Hops {
+		addr := "*"
+		if len(hop.Addrs) > 0 {
+			addr = strings.Join(hop.Addrs, ", ")
+		}
+		color := colorMap["grey"]
+		if hop.Received > 0 && hop.LossPct > 0 {
+			color = colorMap["yellow"]
+		}
+		if hop.AvgMs != nil {
+			fmt.Printf("%s%3d  %-16s avg %.2f ms, loss %.0f%%\n", color, hop.TTL, addr, *hop.AvgMs, hop.LossPct)
+		} else {
+			fmt.Printf("%s%3d  %-16s loss %.0f%%\n", color, hop.TTL, addr, hop.LossPct)
+		}
+	}
+	if !result.Reached && result.Error == "" {
+		fmt.Println(colorMap["yellow"], "[Warning]", result.Target, "was not reached")
+	}
+}
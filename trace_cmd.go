@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// Trace subcommand flags, TRACE_* environment variables give the defaults
+var (
+	TraceProtoFlag   string
+	TraceRoundsFlag  int
+	TraceMaxHopsFlag int
+)
+
+var traceCmd = &cobra.Command{
+	Use:   "trace [host...]",
+	Short: "Trace the path to Shecan servers",
+	Long:  "Trace the path to the given hosts, or to the DNS servers of the selected plan and the Shecan IPs when none are given.",
+	Run: func(cmd *cobra.Command, args []string) {
+		runTrace(cmd, args)
+	},
+}
+
+func runTrace(cmd *cobra.Command, hosts []string) {
+	endpoints = loadEndpoints()
+	if err := configureProxy(ProxyFlag, NoProxyFlag); err != nil {
+		fmt.Println(colorMap["red"], "[Error]", err)
+		return
+	}
+	resetCommandLog()
+
+	opts := traceOptionsFromEnv(3)
+	if cmd.Flags().Changed("proto") {
+		opts.Proto = TraceProtoFlag
+	}
+	if cmd.Flags().Changed("rounds") {
+		opts.Rounds = TraceRoundsFlag
+	}
+	if cmd.Flags().Changed("max-hops") {
+		opts.MaxHops = TraceMaxHopsFlag
+	}
+
+	if len(hosts) == 0 {
+		hosts = getDnsServer(parsePlan(PlanFlag))
+		ips, err := fetchShecanIPs()
+		if err != nil {
+			fmt.Println(colorMap["yellow"], "[Warning]", err)
+		}
+		hosts = append(hosts, ips...)
+	}
+	performTraces(hosts, opts)
+}
+
+func init() {
+	traceCmd.Flags().StringVar(&TraceProtoFlag, "proto", TraceProtoICMP, "Probe protocol: icmp, udp or tcp")
+	traceCmd.Flags().IntVar(&TraceRoundsFlag, "rounds", 3, "Probes sent to every hop")
+	traceCmd.Flags().IntVar(&TraceMaxHopsFlag, "max-hops", 20, "Highest TTL probed")
+	rootCmd.AddCommand(traceCmd)
+}
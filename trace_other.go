@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package main
+
+import "net"
+
+// rawTrace needs to set the TTL of outgoing packets, which is only done on Linux and macOS;
+// everywhere else the traceroute binary is used
+func rawTrace(target net.IP, opts TraceOptions) (TraceResult, error) {
+	return TraceResult{}, errNoICMPSocket
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+const recordedTraceroute = `traceroute to 10.0.0.1 (10.0.0.1), 20 hops max, 60 byte packets
+ 1  192.168.1.1  1.204 ms  0.998 ms  1.101 ms
+ 2  * * *
+ 3  10.10.0.1  12.500 ms 10.10.0.9  14.500 ms *
+ 4  10.0.0.1  30.000 ms  31.000 ms  32.000 ms
+`
+
+const recordedTracert = `
+Tracing route to 10.0.0.1 over a maximum of 20 hops
+
+  1    <1 ms    <1 ms     1 ms  192.168.1.1
+  2     *        *        *     Request timed out.
+  3    31 ms    30 ms    32 ms  10.0.0.1
+
+Trace complete.
+`
+
+func TestParseTraceOutput(t *testing.T) {
+	hops := parseTraceOutput(recordedTraceroute, 3, TraceProtoICMP)
+	if len(hops) != 4 {
+		t.Fatalf("got %d hops: %+v", len(hops), hops)
+	}
+	if hops[0].Received != 3 || hops[0].Addrs[0] != "192.168.1.1" {
+		t.Errorf("hop 1 = %+v", hops[0])
+	}
+	if hops[1].Received != 0 || hops[1].LossPct != 100 || len(hops[1].Addrs) != 0 {
+		t.Errorf("hop 2 = %+v", hops[1])
+	}
+	if hops[2].Received != 2 || len(hops[2].Addrs) != 2 || hops[2].avg() != 13.5 {
+		t.Errorf("hop 3 = %+v", hops[2])
+	}
+
+	hops = parseTraceOutput(recordedTracert, 3, TraceProtoICMP)
+	if len(hops) != 3 || hops[0].Received != 3 || hops[1].Received != 0 || hops[2].Addrs[0] != "10.0.0.1" {
+		t.Errorf("tracert hops = %+v", hops)
+	}
+}
+
+// timeExceeded builds the Time Exceeded message a router sends about a quoted packet
+func timeExceeded(proto byte, dst net.IP, transport []byte) []byte {
+	quoted := make([]byte, 20, 20+len(transport))
+	quoted[0] = 0x45
+	quoted[9] = proto
+	copy(quoted[16:], dst.To4())
+	return append(append([]byte{icmpTimeExceeded, 0, 0, 0, 0, 0, 0, 0}, quoted...), transport...)
+}
+
+func TestMatchTraceProbes(t *testing.T) {
+	target := net.ParseIP("10.0.0.1").To4()
+	hop := net.ParseIP("192.168.1.1").To4()
+	probe := traceProbeID(2, 5)
+
+	echo := buildEchoRequest(0x1234, uint16(probe), time.Now())
+	if got, reached, ok := matchICMPProbe(timeExceeded(1, target, echo[:8]), hop, target, 0x1234); !ok || reached || got != probe {
+		t.Errorf("icmp time exceeded = %d %v %v", got, reached, ok)
+	}
+	if _, _, ok := matchICMPProbe(timeExceeded(1, target, echo[:8]), hop, target, 0x4321); ok {
+		t.Error("matched another trace's echo request")
+	}
+	reply := append([]byte{}, echo...)
+	reply[0] = icmpEchoReply
+	if got, reached, ok := matchICMPProbe(reply, target, target, 0x1234); !ok || !reached || got != probe {
+		t.Errorf("icmp echo reply = %d %v %v", got, reached, ok)
+	}
+
+	udp := make([]byte, 8)
+	binary.BigEndian.PutUint16(udp[0:], 40000)
+	binary.BigEndian.PutUint16(udp[2:], uint16(traceUDPBasePort+probe))
+	unreachable := timeExceeded(17, target, udp)
+	unreachable[0], unreachable[1] = icmpDestUnreachable, 3
+	if got, reached, ok := matchUDPProbe(unreachable, target, 40000); !ok || !reached || got != probe {
+		t.Errorf("udp port unreachable = %d %v %v", got, reached, ok)
+	}
+
+	if got, _, ok := matchTCPProbe(timeExceeded(6, target, udp), target, map[int]int{40000: probe}); !ok || got != probe {
+		t.Errorf("tcp time exceeded = %d %v", got, ok)
+	}
+}
+
+func TestTraceLoopback(t *testing.T) {
+	result, err := rawTrace(net.IPv4(127, 0, 0, 1).To4(), TraceOptions{Proto: TraceProtoICMP, Rounds: 2, MaxHops: 5, Timeout: time.Second})
+	if err == errNoICMPSocket {
+		t.Skip("no raw ICMP socket available")
+	}
+	if err != nil || !result.Reached || len(result.Hops) != 1 || result.Hops[0].Received != 2 {
+		t.Fatalf("rawTrace = %+v, %v", result, err)
+	}
+}
+
+func TestPerformTracesHostname(t *testing.T) {
+	lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	t.Cleanup(func() { lookupIPAddr = net.DefaultResolver.LookupIPAddr })
+
+	results := performTraces([]string{"check.shecan.ir", "185.51.200.0/24", " "}, TraceOptions{Rounds: 1, MaxHops: 1, Timeout: time.Second})
+	if len(results) != 1 || results[0].Target != "check.shecan.ir" || results[0].Error == "" {
+		t.Errorf("results = %+v", results)
+	}
+}
@@ -0,0 +1,246 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// traceProber sends one kind of probe and recognises the ICMP answers to it
+type traceProber interface {
+	send(probe, ttl int) error
+	match(b []byte, from net.IP) (probe int, reached, ok bool)
+	close()
+}
+
+// rawTrace sends every TTL of a round at once and collects the answers on a raw ICMP
+// socket, the only place the routers' Time Exceeded messages show up
+func rawTrace(target net.IP, opts TraceOptions) (TraceResult, error) {
+	conn, err := net.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		return TraceResult{}, errNoICMPSocket
+	}
+	defer conn.Close()
+	listener := conn.(*net.IPConn)
+
+	events := make(chan traceEvent, 64)
+	done := make(chan struct{})
+	defer close(done)
+	emit := func(ev traceEvent) {
+		select {
+		case events <- ev:
+		case <-done:
+		}
+	}
+
+	var prober traceProber
+	switch opts.Proto {
+	case TraceProtoUDP:
+		prober, err = newUDPTraceProber(target)
+	case TraceProtoTCP:
+		prober = newTCPTraceProber(target, opts.Timeout, emit)
+	default:
+		prober = &icmpTraceProber{conn: listener, target: target, id: nextICMPID()}
+	}
+	if err != nil {
+		return TraceResult{}, err
+	}
+	defer prober.close()
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := listener.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			ip := from.(*net.IPAddr).IP
+			if probe, reached, ok := prober.match(buf[:n], ip); ok {
+				emit(traceEvent{probe: probe, from: ip.String(), reached: reached, at: time.Now()})
+			}
+		}
+	}()
+
+	result := TraceResult{Proto: opts.Proto, Method: PingMethodRaw, Rounds: opts.Rounds}
+	hops := make([]hopAccumulator, opts.MaxHops+1)
+	lastTTL := opts.MaxHops
+	for round := 0; round < opts.Rounds; round++ {
+		sent := map[int]time.Time{}
+		for ttl := 1; ttl <= lastTTL; ttl++ {
+			probe := traceProbeID(round, ttl)
+			sent[probe] = time.Now()
+			if err := prober.send(probe, ttl); err != nil {
+				delete(sent, probe)
+				result.Error = err.Error()
+			}
+			hops[ttl].sent++
+		}
+
+		timer := time.NewTimer(opts.Timeout)
+	collect:
+		for len(sent) > 0 {
+			select {
+			case ev := <-events:
+				start, ok := sent[ev.probe]
+				ttl := ev.probe & 0xff
+				if !ok || ttl > lastTTL {
+					continue
+				}
+				delete(sent, ev.probe)
+				hops[ttl].add(ev.from, ev.at.Sub(start))
+				if ev.reached {
+					result.Reached = true
+					// probes beyond the target were answered by the target too, forget them
+					for probe := range sent {
+						if probe&0xff > ttl {
+							delete(sent, probe)
+						}
+					}
+					lastTTL = ttl
+				}
+			case <-timer.C:
+				break collect
+			}
+		}
+		timer.Stop()
+	}
+	return buildTraceResult(result, hops, lastTTL), nil
+}
+
+// setTTL sets the unicast TTL of the packets sent on c
+func setTTL(c syscall.Conn, ttl int) error {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TTL, ttl)
+	}); err != nil {
+		return err
+	}
+	return serr
+}
+
+// icmpTraceProber sends echo requests on the raw socket that also receives the answers
+type icmpTraceProber struct {
+	conn   *net.IPConn
+	target net.IP
+	id     uint16
+}
+
+func (p *icmpTraceProber) send(probe, ttl int) error {
+	if err := setTTL(p.conn, ttl); err != nil {
+		return err
+	}
+	_, err := p.conn.WriteTo(buildEchoRequest(p.id, uint16(probe), time.Now()), &net.IPAddr{IP: p.target})
+	return err
+}
+
+func (p *icmpTraceProber) match(b []byte, from net.IP) (int, bool, bool) {
+	return matchICMPProbe(b, from, p.target, p.id)
+}
+
+func (p *icmpTraceProber) close() {}
+
+// udpTraceProber sends datagrams to unused high ports, the target answers Port Unreachable
+type udpTraceProber struct {
+	conn    *net.UDPConn
+	target  net.IP
+	srcPort int
+}
+
+func newUDPTraceProber(target net.IP) (*udpTraceProber, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	return &udpTraceProber{conn: conn, target: target, srcPort: conn.LocalAddr().(*net.UDPAddr).Port}, nil
+}
+
+func (p *udpTraceProber) send(probe, ttl int) error {
+	if err := setTTL(p.conn, ttl); err != nil {
+		return err
+	}
+	_, err := p.conn.WriteTo(make([]byte, 32), &net.UDPAddr{IP: p.target, Port: traceUDPBasePort + probe})
+	return err
+}
+
+func (p *udpTraceProber) match(b []byte, from net.IP) (int, bool, bool) {
+	return matchUDPProbe(b, p.target, p.srcPort)
+}
+
+func (p *udpTraceProber) close() {
+	p.conn.Close()
+}
+
+// tcpTraceProber opens connections to port 443 with a limited TTL. Routers answer the
+// SYN with Time Exceeded, the target itself completes or refuses the handshake.
+type tcpTraceProber struct {
+	target  net.IP
+	timeout time.Duration
+	emit    func(traceEvent)
+
+	mu    sync.Mutex
+	ports map[int]int // source port to probe ID
+}
+
+func newTCPTraceProber(target net.IP, timeout time.Duration, emit func(traceEvent)) *tcpTraceProber {
+	return &tcpTraceProber{target: target, timeout: timeout, emit: emit, ports: map[int]int{}}
+}
+
+func (p *tcpTraceProber) send(probe, ttl int) error {
+	dialer := net.Dialer{
+		Timeout: p.timeout,
+		Control: func(network, address string, c syscall.RawConn) error {
+			var serr error
+			err := c.Control(func(fd uintptr) {
+				if serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TTL, ttl); serr != nil {
+					return
+				}
+				// bind first so the source port is known before the SYN goes out
+				if serr = syscall.Bind(int(fd), &syscall.SockaddrInet4{}); serr != nil {
+					return
+				}
+				sa, err := syscall.Getsockname(int(fd))
+				if err != nil {
+					serr = err
+					return
+				}
+				if in4, ok := sa.(*syscall.SockaddrInet4); ok {
+					p.mu.Lock()
+					p.ports[in4.Port] = probe
+					p.mu.Unlock()
+				}
+			})
+			if err != nil {
+				return err
+			}
+			return serr
+		},
+	}
+
+	go func() {
+		conn, err := dialer.Dial("tcp4", net.JoinHostPort(p.target.String(), strconv.Itoa(traceTCPPort)))
+		if err == nil {
+			conn.Close()
+		}
+		if err == nil || errors.Is(err, syscall.ECONNREFUSED) {
+			p.emit(traceEvent{probe: probe, from: p.target.String(), reached: true, at: time.Now()})
+		}
+	}()
+	return nil
+}
+
+func (p *tcpTraceProber) match(b []byte, from net.IP) (int, bool, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return matchTCPProbe(b, p.target, p.ports)
+}
+
+func (p *tcpTraceProber) close() {}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Endpoints holds the base URLs of the Shecan services the diagnostic talks to
+type Endpoints struct {
+	Shecan string // plan DNS lists and the public IP echo
+	Check  string // check.shecan.ir, its ip-list and the propagation check
+}
+
+var endpoints = loadEndpoints()
+
+// loadEndpoints reads SHECAN_BASE_URL and CHECK_BASE_URL, defaulting to the production hosts
+func loadEndpoints() Endpoints {
+	return Endpoints{
+		Shecan: strings.TrimRight(envString("SHECAN_BASE_URL", "https://shecan.ir"), "/"),
+		Check:  strings.TrimRight(envString("CHECK_BASE_URL", "https://check.shecan.ir"), "/"),
+	}
+}
+
+// DialContextFunc has the signature of net.Dialer.DialContext
+type DialContextFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+var (
+	// dialContext opens every HTTP and TLS probe connection when set; tests use it to fake the network
+	dialContext DialContextFunc
+	// tlsRootCAs replaces the system roots when set
+	tlsRootCAs *x509.CertPool
+	// lookupIPAddr resolves names through the active resolver
+	lookupIPAddr = net.DefaultResolver.LookupIPAddr
+	// newTransport builds the RoundTripper behind every HTTP client
+	newTransport = defaultTransport
+)
+
+func dialerFor(timeout time.Duration) DialContextFunc {
+	if dialContext != nil {
+		return dialContext
+	}
+	return (&net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+}
+
+func defaultTransport(timeout time.Duration, overrideHost string) http.RoundTripper {
+	return &http.Transport{
+		TLSClientConfig: &tls.Config{
+			ServerName: overrideHost,
+			RootCAs:    tlsRootCAs,
+			// InsecureSkipVerify: true, // Uncomment if using self-signed certs
+		},
+		DialContext: dialerFor(timeout),
+		Proxy:       proxyForRequest,
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// vpnClients maps process name prefixes, lower case and without .exe, to the VPN or proxy client they belong to
+var vpnClients = []struct{ prefix, name string }{
+	{"openvpn", "OpenVPN"},
+	{"wireguard", "WireGuard"},
+	{"wg-quick", "WireGuard"},
+	{"xray", "Xray"},
+	{"v2ray", "V2Ray"},
+	{"sing-box", "sing-box"},
+	{"clash", "Clash"},
+	{"mihomo", "Clash"},
+	{"nekoray", "NekoRay"},
+	{"nekobox", "NekoRay"},
+	{"hiddify", "Hiddify"},
+	{"outline", "Outline"},
+	{"psiphon", "Psiphon"},
+	{"tor", "Tor"},
+	{"openconnect", "OpenConnect"},
+	{"warp-svc", "Cloudflare WARP"},
+}
+
+// localProxyPorts are the default ports of the SOCKS and HTTP proxies local clients open
+var localProxyPorts = map[int]string{
+	1080:  "SOCKS",
+	2080:  "NekoRay",
+	7890:  "Clash",
+	7891:  "Clash SOCKS",
+	8118:  "Privoxy",
+	9050:  "Tor SOCKS",
+	10808: "v2rayN SOCKS",
+	10809: "v2rayN HTTP",
+	20170: "v2rayA SOCKS",
+}
+
+// VPNProcess is a running VPN or proxy client
+type VPNProcess struct {
+	Client  string `json:"client"`
+	Process string `json:"process"`
+	PID     int    `json:"pid"`
+}
+
+// VPNDetection collects signs of VPN and proxy clients that bypass the system DNS
+type VPNDetection struct {
+	Tunnels          []string        `json:"tunnels,omitempty"`
+	DefaultViaTunnel string          `json:"default_via_tunnel,omitempty"`
+	Processes        []VPNProcess    `json:"processes,omitempty"`
+	LocalProxies     []LocalListener `json:"local_proxies,omitempty"`
+	Culprit          string          `json:"culprit,omitempty"`
+}
+
+// Detected reports whether any VPN or proxy client was found
+func (v *VPNDetection) Detected() bool {
+	return v != nil && v.Culprit != ""
+}
+
+// vpnClientName returns the client a process belongs to, or "" for other processes
+func vpnClientName(process string) string {
+	lower := strings.TrimSuffix(strings.ToLower(process), ".exe")
+	for _, client := range vpnClients {
+		// tor must not match torrent clients
+		if client.prefix == "tor" && lower != "tor" {
+			continue
+		}
+		if strings.HasPrefix(lower, client.prefix) {
+			return client.name
+		}
+	}
+	return ""
+}
+
+// findLocalProxies returns the loopback TCP listeners on well-known proxy client ports
+func findLocalProxies(listeners []LocalListener) []LocalListener {
+	var proxies []LocalListener
+	for _, l := range listeners {
+		ip := net.ParseIP(l.Address)
+		if l.Proto != "tcp" || ip == nil || !(ip.IsLoopback() || ip.IsUnspecified()) {
+			continue
+		}
+		if _, ok := localProxyPorts[l.Port]; ok || vpnClientName(l.Process) != "" {
+			proxies = append(proxies, l)
+		}
+	}
+	return proxies
+}
+
+// detectVPN looks for tunnels, VPN processes and local proxies. The culprit is the
+// most specific sign: a named client, then a tunnel carrying the default route,
+// then any tunnel, then a local proxy port.
+func detectVPN(inv *NetworkInventory, processes []LocalProcess, listeners []LocalListener) *VPNDetection {
+	v := &VPNDetection{}
+	if inv != nil {
+		v.Tunnels = inv.Tunnels
+		if inv.DefaultViaTunnel {
+			v.DefaultViaTunnel = inv.DefaultInterface
+		}
+	}
+	for _, process := range processes {
+		if client := vpnClientName(process.Name); client != "" {
+			v.Processes = append(v.Processes, VPNProcess{Client: client, Process: process.Name, PID: process.PID})
+		}
+	}
+	v.LocalProxies = findLocalProxies(listeners)
+
+	switch {
+	case len(v.Processes) > 0:
+		var clients []string
+		for _, p := range v.Processes {
+			if !contains(clients, p.Client) {
+				clients = append(clients, p.Client)
+			}
+		}
+		v.Culprit = strings.Join(clients, ", ") + " running"
+		if v.DefaultViaTunnel != "" {
+			v.Culprit += " with the default route on " + v.DefaultViaTunnel
+		}
+	case v.DefaultViaTunnel != "":
+		v.Culprit = "a VPN on " + v.DefaultViaTunnel + " carrying the default route"
+	case len(v.Tunnels) > 0:
+		v.Culprit = "a VPN tunnel (" + strings.Join(v.Tunnels, ", ") + ")"
+	case len(v.LocalProxies) > 0:
+		l := v.LocalProxies[0]
+		name := localProxyPorts[l.Port]
+		if name == "" {
+			name = l.Process
+		}
+		v.Culprit = "a local " + name + " proxy on port " + strconv.Itoa(l.Port)
+	}
+	return v
+}
+
+func printVPNDetection(v *VPNDetection) {
+	if !v.Detected() {
+		return
+	}
+	fmt.Println(colorMap["yellow"], "[Warning] VPN or proxy client found:", v.Culprit)
+	for _, l := range v.LocalProxies {
+		fmt.Println(colorMap["yellow"], "[Warning] Local proxy listening:", l)
+	}
+}
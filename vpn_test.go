@@ -0,0 +1,99 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+const recordedProcNetTCP = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0100007F:2A38 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 31337 1 0000000000000000 100 0 0 10 0
+   1: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1201 1 0000000000000000 100 0 0 10 0
+   2: 0100007F:2A38 0100007F:C350 01 00000000:00000000 00:00000000 00000000  1000        0 31338 1 0000000000000000 20 4 30 10 -1
+`
+
+const recordedProcNetUDP6 = `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops
+  5: 00000000000000000000000001000000:0035 00000000000000000000000000000000:0000 07 00000000:00000000 00:00000000 00000000   101        0 2020 2 0000000000000000 0
+`
+
+func TestParseLocalSockets(t *testing.T) {
+	want := []LocalListener{
+		{Proto: "tcp", Address: "127.0.0.1", Port: 10808, inode: "31337"},
+		{Proto: "tcp", Address: "0.0.0.0", Port: 22, inode: "1201"},
+	}
+	if got := parseProcNetSockets(recordedProcNetTCP, "tcp", procTCPListen); !reflect.DeepEqual(got, want) {
+		t.Errorf("tcp = %+v", got)
+	}
+	if got := parseProcNetSockets(recordedProcNetUDP6, "udp", procUDPUnbound); len(got) != 1 || got[0].Address != "::1" || got[0].Port != 53 {
+		t.Errorf("udp6 = %+v", got)
+	}
+
+	mac := "Active Internet connections (including servers)\n" +
+		"Proto Recv-Q Send-Q  Local Address          Foreign Address        (state)\n" +
+		"tcp4       0      0  127.0.0.1.7890         *.*                    LISTEN\n" +
+		"tcp4       0      0  192.168.1.20.50123     1.1.1.1.443            ESTABLISHED\n" +
+		"udp4       0      0  *.53                   *.*\n"
+	want = []LocalListener{{Proto: "tcp", Address: "127.0.0.1", Port: 7890}, {Proto: "udp", Address: "0.0.0.0", Port: 53}}
+	if got := parseNetstat(mac); !reflect.DeepEqual(got, want) {
+		t.Errorf("macOS netstat = %+v", got)
+	}
+	windows := "  Proto  Local Address          Foreign Address        State           PID\n" +
+		"  TCP    127.0.0.1:10809        0.0.0.0:0              LISTENING       5120\n" +
+		"  TCP    192.168.1.20:50123     1.1.1.1:443            ESTABLISHED     5120\n" +
+		"  UDP    [::1]:53               *:*                                    880\n"
+	want = []LocalListener{{Proto: "tcp", Address: "127.0.0.1", Port: 10809, PID: 5120}, {Proto: "udp", Address: "::1", Port: 53, PID: 880}}
+	if got := parseNetstat(windows); !reflect.DeepEqual(got, want) {
+		t.Errorf("Windows netstat = %+v", got)
+	}
+
+	if got := parseTasklist(`"v2rayN.exe","5120","Console","1","80,112 K"`); len(got) != 1 || got[0].Name != "v2rayN" || got[0].PID != 5120 {
+		t.Errorf("tasklist = %+v", got)
+	}
+	if got := parsePS("  1 /sbin/launchd\n 812 /Applications/ClashX.app/Contents/MacOS/ClashX\n"); len(got) != 2 || got[1].Name != "ClashX" {
+		t.Errorf("ps = %+v", got)
+	}
+}
+
+// testInventory summarizes interfaces and routes the way collectNetworkInventory does
+func testInventory(routes []RouteInfo, interfaces ...InterfaceInfo) *NetworkInventory {
+	inv := &NetworkInventory{Interfaces: interfaces, DefaultRoutes: routes}
+	inv.summarize()
+	return inv
+}
+
+func TestDetectVPN(t *testing.T) {
+	tunnel := func(name string, addrs ...string) InterfaceInfo {
+		return InterfaceInfo{Name: name, Flags: []string{"up", "pointtopoint"}, IPv6: addrs, Kind: InterfaceTunnel, Tunnel: true}
+	}
+	en0 := InterfaceInfo{Name: "en0", Flags: []string{"up", "broadcast"}, IPv4: []string{"192.168.1.20/24"}, Kind: InterfacePhysical}
+	viaEn0 := []RouteInfo{{Family: "ipv4", Kind: RouteLookup, Interface: "en0", Gateway: "192.168.1.1"}}
+	// macOS keeps these up for iCloud and Handoff with link-local addresses only
+	system := []InterfaceInfo{en0, tunnel("utun0", "fe80::1/64"), tunnel("utun1", "fe80::2/64"), tunnel("utun2", "fe80::3/64"), tunnel("utun3")}
+
+	macIdle := testInventory(viaEn0, system...)
+	idleTunnel := testInventory(viaEn0, append(system, tunnel("utun4", "fe80::4/64", "fd7a:115c:a1e0::1/128"))...)
+	tunnelRoute := testInventory([]RouteInfo{{Family: "ipv4", Kind: RouteLookup, Interface: "wg0"}}, en0, tunnel("wg0"))
+	processes := []LocalProcess{{PID: 1, Name: "systemd"}, {PID: 812, Name: "xray"}, {PID: 900, Name: "transmission"}, {PID: 901, Name: "torrent"}}
+	proxy := []LocalListener{{Proto: "tcp", Address: "127.0.0.1", Port: 10808}, {Proto: "tcp", Address: "0.0.0.0", Port: 22}}
+
+	for name, tc := range map[string]struct {
+		inv       *NetworkInventory
+		processes []LocalProcess
+		listeners []LocalListener
+		culprit   string
+	}{
+		"clean":          {&NetworkInventory{}, processes[:1], nil, ""},
+		"system tunnels": {macIdle, processes[:1], nil, ""},
+		"process":        {tunnelRoute, processes, nil, "Xray running with the default route on wg0"},
+		"route":          {tunnelRoute, processes[:1], nil, "a VPN on wg0 carrying the default route"},
+		"tunnel":         {idleTunnel, nil, nil, "a VPN tunnel (utun4)"},
+		"local proxy":    {nil, nil, proxy, "a local v2rayN SOCKS proxy on port 10808"},
+	} {
+		v := detectVPN(tc.inv, tc.processes, tc.listeners)
+		if v.Culprit != tc.culprit || v.Detected() != (tc.culprit != "") {
+			t.Errorf("%s: culprit %q", name, v.Culprit)
+		}
+	}
+	if v := detectVPN(nil, processes, proxy); len(v.Processes) != 1 || len(v.LocalProxies) != 1 {
+		t.Errorf("processes %+v, proxies %+v", v.Processes, v.LocalProxies)
+	}
+}